@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	auditLogPath       = "audit.log"
+	auditMaxSizeMB     = 10
+	auditMaxBackups    = 5
+	auditDefaultLimit  = 200
+	auditMaxQueryLimit = 5000
+)
+
+// Audit actions
+const (
+	AuditStart        = "start"
+	AuditStop         = "stop"
+	AuditStartAll     = "start_all"
+	AuditStopAll      = "stop_all"
+	AuditRestart      = "restart"
+	AuditRestartAll   = "restart_all"
+	AuditAutoRestart  = "set_auto_restart"
+	AuditConfigWrite  = "config_write"
+	AuditGroupStart   = "group_start"
+	AuditGroupStop    = "group_stop"
+	AuditScale        = "scale"
+	AuditConsole      = "console"
+	AuditRemove       = "remove"
+	AuditConfigReload = "config_reload"
+)
+
+// ConfigChange is a single field difference between two config versions.
+type ConfigChange struct {
+	Path   string `json:"path"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+type AuditEntry struct {
+	TimestampMS int64          `json:"timestamp_ms"`
+	RemoteAddr  string         `json:"remote_addr"`
+	User        string         `json:"user,omitempty"`
+	Action      string         `json:"action"`
+	Target      string         `json:"target,omitempty"`
+	Params      map[string]any `json:"params,omitempty"`
+	Result      string         `json:"result"` // "ok", "partial" or "error"
+	Error       string         `json:"error,omitempty"`
+	Changes     []ConfigChange `json:"changes,omitempty"`
+}
+
+// AuditLog is an append-only JSON-lines file, rotated like process logs.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func newAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Record appends an entry, stamping it with the current time.
+func (al *AuditLog) Record(entry AuditEntry) {
+	entry.TimestampMS = time.Now().UnixMilli()
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("[audit] failed to encode entry: %v", err)
+		return
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if _, err := rotateLog(al.path, auditMaxSizeMB, auditMaxBackups, 0); err != nil {
+		log.Printf("[audit] failed to rotate %s: %v", al.path, err)
+	}
+	f, err := os.OpenFile(al.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("[audit] failed to open %s: %v", al.path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("[audit] failed to write entry: %v", err)
+	}
+}
+
+type auditFilter struct {
+	User    string
+	Action  string
+	Target  string
+	SinceMS int64
+	UntilMS int64
+	Limit   int
+}
+
+func (f *auditFilter) match(e *AuditEntry) bool {
+	return (f.User == "" || e.User == f.User) &&
+		(f.Action == "" || e.Action == f.Action) &&
+		(f.Target == "" || e.Target == f.Target) &&
+		(f.SinceMS == 0 || e.TimestampMS >= f.SinceMS) &&
+		(f.UntilMS == 0 || e.TimestampMS <= f.UntilMS)
+}
+
+// Query returns matching entries in chronological order, keeping the most
+// recent f.Limit. Rotated backups are read oldest first.
+func (al *AuditLog) Query(f auditFilter) ([]AuditEntry, error) {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	paths := make([]string, 0, auditMaxBackups+1)
+	for i := auditMaxBackups; i >= 1; i-- {
+		paths = append(paths, al.path+"."+strconv.Itoa(i))
+	}
+	paths = append(paths, al.path)
+
+	result := []AuditEntry{}
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for scanner.Scan() {
+			var e AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				continue // skip a line torn by a crash
+			}
+			if f.match(&e) {
+				result = append(result, e)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if f.Limit > 0 && len(result) > f.Limit {
+		result = result[len(result)-f.Limit:]
+	}
+	return result, nil
+}
+
+// recordAudit stamps an entry with the request's caller and outcome.
+func (pm *ProcessManager) recordAudit(r *http.Request, entry AuditEntry, err error) {
+	pm.recordAuditAs(requestActor(r), entry, err)
+}
+
+func (pm *ProcessManager) recordAuditAs(a actor, entry AuditEntry, err error) {
+	entry.RemoteAddr = a.RemoteAddr
+	entry.User = a.User.Name
+	if entry.Result == "" {
+		entry.Result = "ok"
+		if err != nil {
+			entry.Result = "error"
+		}
+	}
+	if err != nil {
+		entry.Error = pm.secrets.Redact(err.Error())
+	}
+	pm.audit.Record(entry)
+}
+
+func (pm *ProcessManager) handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermAuditRead, nil) {
+		return
+	}
+
+	q := r.URL.Query()
+	f := auditFilter{
+		User:   q.Get("user"),
+		Action: q.Get("action"),
+		Target: q.Get("process"),
+		Limit:  auditDefaultLimit,
+	}
+	if s := q.Get("since"); s != "" {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			f.SinceMS = n
+		}
+	}
+	if s := q.Get("until"); s != "" {
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			f.UntilMS = n
+		}
+	}
+	if s := q.Get("limit"); s != "" {
+		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= auditMaxQueryLimit {
+			f.Limit = n
+		}
+	}
+
+	entries, err := pm.audit.Query(f)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read audit log")
+		return
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// diffConfig lists every leaf field that differs between two configs.
+// Array elements carrying an "id" or "name" are keyed by it so that
+// reordering processes does not show up as a change.
+func diffConfig(before, after *Config) []ConfigChange {
+	a := flattenConfig(before)
+	b := flattenConfig(after)
+
+	paths := make(map[string]bool, len(a)+len(b))
+	for p := range a {
+		paths[p] = true
+	}
+	for p := range b {
+		paths[p] = true
+	}
+
+	changes := []ConfigChange{}
+	for p := range paths {
+		va, okA := a[p]
+		vb, okB := b[p]
+		if okA && okB && reflect.DeepEqual(va, vb) {
+			continue
+		}
+		changes = append(changes, ConfigChange{Path: p, Before: va, After: vb})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+func flattenConfig(cfg *Config) map[string]any {
+	out := make(map[string]any)
+	if cfg == nil {
+		return out
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return out
+	}
+	flattenValue("", doc, out)
+	return out
+}
+
+func flattenValue(prefix string, v any, out map[string]any) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			p := k
+			if prefix != "" {
+				p = prefix + "." + k
+			}
+			flattenValue(p, child, out)
+		}
+	case []any:
+		keyed := len(t) > 0
+		for _, el := range t {
+			if elementKey(el) == "" {
+				keyed = false
+				break
+			}
+		}
+		if !keyed {
+			out[prefix] = v // scalar lists such as args are compared whole
+			return
+		}
+		for _, el := range t {
+			flattenValue(fmt.Sprintf("%s[%s]", prefix, elementKey(el)), el, out)
+		}
+	default:
+		out[prefix] = v
+	}
+}
+
+func elementKey(v any) string {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return ""
+	}
+	for _, k := range []string{"id", "name"} {
+		if s, ok := m[k].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Permissions that can be granted to a role.
+const (
+	PermView        = "view"
+	PermLogsRead    = "logs:read"
+	PermControl     = "control"
+	PermConsole     = "console"
+	PermConfigRead  = "config:read"
+	PermConfigWrite = "config:write"
+	PermAuditRead   = "audit:read"
+	PermAll         = "*"
+)
+
+var knownPermissions = []string{
+	PermView, PermLogsRead, PermControl, PermConsole, PermConfigRead, PermConfigWrite, PermAuditRead, PermAll,
+}
+
+// RoleConfig grants a set of permissions. A role with no processes and no
+// categories applies to every process and to global actions such as config
+// writes; otherwise it only applies to the listed process IDs and categories.
+type RoleConfig struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+	Processes   []string `json:"processes,omitempty"`
+	Categories  []string `json:"categories,omitempty"`
+}
+
+// UserConfig identifies an API client by the SHA-256 hex digest of its bearer token.
+type UserConfig struct {
+	Name        string   `json:"name"`
+	TokenSHA256 string   `json:"token_sha256"`
+	Roles       []string `json:"roles"`
+}
+
+// AuthConfig enables token authentication when at least one user is defined.
+type AuthConfig struct {
+	Users []UserConfig `json:"users"`
+	Roles []RoleConfig `json:"roles"`
+}
+
+// User is the authenticated identity attached to a request.
+type User struct {
+	Name     string
+	roles    []RoleConfig
+	allowAll bool
+}
+
+// localUser is used for every request when authentication is disabled.
+var localUser = &User{allowAll: true}
+
+// appliesTo reports whether the role is in scope for the given process.
+// An empty id denotes a global action, which only unscoped roles cover.
+func (rc *RoleConfig) appliesTo(id, category string) bool {
+	if len(rc.Processes) == 0 && len(rc.Categories) == 0 {
+		return true
+	}
+	if id == "" {
+		return false
+	}
+	// A role naming a replicated process covers all its replicas
+	return slices.Contains(rc.Processes, id) || slices.Contains(rc.Processes, replicaBase(id)) ||
+		(category != "" && slices.Contains(rc.Categories, category))
+}
+
+// can reports whether the user holds perm for the process with the given
+// ID and category. Pass an empty id for global actions.
+func (u *User) can(perm, id, category string) bool {
+	if u.allowAll {
+		return true
+	}
+	for i := range u.roles {
+		rc := &u.roles[i]
+		if !rc.appliesTo(id, category) {
+			continue
+		}
+		if slices.Contains(rc.Permissions, perm) || slices.Contains(rc.Permissions, PermAll) {
+			return true
+		}
+	}
+	return false
+}
+
+func (u *User) canProcess(perm string, pc *ProcessConfig) bool {
+	return u.can(perm, pc.ID, pc.Category)
+}
+
+// visibleStatuses filters statuses down to the processes the user may view.
+func (u *User) visibleStatuses(statuses []ProcessStatus) []ProcessStatus {
+	if u.allowAll {
+		return statuses
+	}
+	result := make([]ProcessStatus, 0, len(statuses))
+	for _, s := range statuses {
+		if u.can(PermView, s.ID, s.Category) {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// canManageAuth reports whether the user may see and change the auth
+// section. Only unscoped "*" roles can, so nobody grants themselves more.
+func (u *User) canManageAuth() bool {
+	return u.can(PermAll, "", "")
+}
+
+// redactAuth returns cfg without its auth section unless u may manage it.
+// cfg itself is left untouched.
+func redactAuth(cfg *Config, u *User) *Config {
+	if cfg.Auth == nil || u.canManageAuth() {
+		return cfg
+	}
+	c := *cfg
+	c.Auth = nil
+	return &c
+}
+
+// authorizeAuthChange checks that the request's user may replace the auth
+// section before with after, writing 403 when denied.
+func authorizeAuthChange(w http.ResponseWriter, r *http.Request, before, after *AuthConfig) bool {
+	if reflect.DeepEqual(before, after) || requestUser(r).canManageAuth() {
+		return true
+	}
+	writeError(w, http.StatusForbidden, "permission denied: changing auth requires "+PermAll)
+	return false
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupUser resolves a bearer token against the configured users.
+func (ac *AuthConfig) lookupUser(token string) *User {
+	digest := []byte(hashToken(token))
+	for _, uc := range ac.Users {
+		if subtle.ConstantTimeCompare(digest, []byte(strings.ToLower(uc.TokenSHA256))) != 1 {
+			continue
+		}
+		u := &User{Name: uc.Name}
+		for _, rc := range ac.Roles {
+			if slices.Contains(uc.Roles, rc.Name) {
+				u.roles = append(u.roles, rc)
+			}
+		}
+		return u
+	}
+	return nil
+}
+
+func (ac *AuthConfig) enabled() bool {
+	return ac != nil && len(ac.Users) > 0
+}
+
+// validate checks that role references and permission names are consistent.
+func (ac *AuthConfig) validate() error {
+	if ac == nil {
+		return nil
+	}
+	roles := make(map[string]bool, len(ac.Roles))
+	for _, rc := range ac.Roles {
+		if rc.Name == "" {
+			return fmt.Errorf("auth role name must not be empty")
+		}
+		for _, p := range rc.Permissions {
+			if !slices.Contains(knownPermissions, p) {
+				return fmt.Errorf("auth role %s: unknown permission %q", rc.Name, p)
+			}
+		}
+		roles[rc.Name] = true
+	}
+	for _, uc := range ac.Users {
+		if uc.Name == "" {
+			return fmt.Errorf("auth user name must not be empty")
+		}
+		if len(uc.TokenSHA256) != sha256.Size*2 {
+			return fmt.Errorf("auth user %s: token_sha256 must be a hex SHA-256 digest", uc.Name)
+		}
+		for _, r := range uc.Roles {
+			if !roles[r] {
+				return fmt.Errorf("auth user %s: unknown role %q", uc.Name, r)
+			}
+		}
+	}
+	return nil
+}
+
+type userCtxKey struct{}
+
+// requestUser returns the user attached by authMiddleware.
+func requestUser(r *http.Request) *User {
+	if u, ok := r.Context().Value(userCtxKey{}).(*User); ok {
+		return u
+	}
+	return localUser
+}
+
+// actor identifies who initiated an action, for auditing work that
+// outlives the HTTP request.
+type actor struct {
+	User       *User
+	RemoteAddr string
+}
+
+func requestActor(r *http.Request) actor {
+	return actor{User: requestUser(r), RemoteAddr: r.RemoteAddr}
+}
+
+// bearerToken extracts the token from the Authorization header, falling back
+// to the token query parameter for browser WebSocket connections.
+func bearerToken(r *http.Request) string {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+	}
+	return r.URL.Query().Get("token")
+}
+
+// authMiddleware authenticates API and WebSocket requests. Frontend assets
+// are public so the browser can load the UI before it has a token.
+func (pm *ProcessManager) authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/api/") && r.URL.Path != "/ws" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		pm.mu.RLock()
+		ac := pm.cfg.Auth
+		pm.mu.RUnlock()
+
+		user := localUser
+		if ac.enabled() {
+			token := bearerToken(r)
+			if token == "" {
+				writeError(w, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			if user = ac.lookupUser(token); user == nil {
+				writeError(w, http.StatusUnauthorized, "invalid token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userCtxKey{}, user)))
+	})
+}
+
+// authorize checks perm for the request's user, writing 403 when denied.
+// Pass a nil pc for global actions.
+func authorize(w http.ResponseWriter, r *http.Request, perm string, pc *ProcessConfig) bool {
+	u := requestUser(r)
+	allowed := false
+	if pc == nil {
+		allowed = u.can(perm, "", "")
+	} else {
+		allowed = u.canProcess(perm, pc)
+	}
+	if !allowed {
+		writeError(w, http.StatusForbidden, "permission denied: "+perm)
+	}
+	return allowed
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// settings holds connection options. Flags override the environment,
+// which overrides the config file.
+type settings struct {
+	URL        string `json:"url"`
+	Token      string `json:"token"`
+	Insecure   bool   `json:"insecure"`
+	UnixSocket string `json:"unix_socket"`
+}
+
+func defaultConfigPath() string {
+	if p := os.Getenv("SMCTL_CONFIG"); p != "" {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "smctl.json")
+}
+
+// loadSettings reads the config file, if any, then applies the environment.
+func loadSettings(path string) (settings, error) {
+	s := settings{URL: "http://localhost:8090"}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := json.Unmarshal(data, &s); err != nil {
+				return s, fmt.Errorf("invalid config file %s: %w", path, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return s, err
+		}
+	}
+	if v := os.Getenv("SMCTL_URL"); v != "" {
+		s.URL = v
+	}
+	if v := os.Getenv("SMCTL_TOKEN"); v != "" {
+		s.Token = v
+	}
+	if v := os.Getenv("SMCTL_UNIX_SOCKET"); v != "" {
+		s.UnixSocket = v
+	}
+	if os.Getenv("SMCTL_INSECURE") == "1" {
+		s.Insecure = true
+	}
+	return s, nil
+}
+
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+func newClient(s settings) *client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if s.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	base := strings.TrimRight(s.URL, "/")
+	if s.UnixSocket != "" {
+		socket := s.UnixSocket
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+		base = "http://unix"
+	}
+	return &client{
+		base:  base,
+		token: s.Token,
+		http:  &http.Client{Transport: transport, Timeout: 2 * time.Minute},
+	}
+}
+
+// apiError is returned for non-2xx responses, carrying the server's message.
+type apiError struct {
+	Status  int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+// do sends a request and returns the raw response body. A 207 Multi-Status
+// is returned as a body together with an error so callers can show details.
+func (c *client) do(method, path string, body []byte) ([]byte, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, c.base+path, rd)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 || resp.StatusCode == http.StatusMultiStatus {
+		var e struct {
+			Error  string `json:"error"`
+			Status string `json:"status"`
+		}
+		msg := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &e) == nil && (e.Error != "" || e.Status != "") {
+			msg = firstNonEmpty(e.Error, e.Status)
+		}
+		return data, &apiError{Status: resp.StatusCode, Message: msg}
+	}
+	return data, nil
+}
+
+// getJSON decodes a GET response into v and returns the raw body as well.
+func (c *client) getJSON(path string, v any) ([]byte, error) {
+	data, err := c.do(http.MethodGet, path, nil)
+	if err != nil {
+		return data, err
+	}
+	return data, json.Unmarshal(data, v)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadSettings(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "smctl.json")
+	if err := os.WriteFile(file, []byte(`{"url":"http://file:1","token":"file-token"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		env     map[string]string
+		want    settings
+		wantErr bool
+	}{
+		{name: "defaults", path: filepath.Join(dir, "missing.json"), want: settings{URL: "http://localhost:8090"}},
+		{name: "no config path", path: "", want: settings{URL: "http://localhost:8090"}},
+		{name: "file", path: file, want: settings{URL: "http://file:1", Token: "file-token"}},
+		{
+			name: "environment overrides the file",
+			path: file,
+			env:  map[string]string{"SMCTL_URL": "http://env:2", "SMCTL_UNIX_SOCKET": "/run/sm.sock", "SMCTL_INSECURE": "1"},
+			want: settings{URL: "http://env:2", Token: "file-token", UnixSocket: "/run/sm.sock", Insecure: true},
+		},
+		{name: "invalid file", path: bad, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"SMCTL_URL", "SMCTL_TOKEN", "SMCTL_UNIX_SOCKET", "SMCTL_INSECURE"} {
+				t.Setenv(name, tt.env[name])
+			}
+			got, err := loadSettings(tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{"status":"ok"}`))
+		case "/conflict":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":"busy"}`))
+		case "/partial":
+			w.WriteHeader(http.StatusMultiStatus)
+			w.Write([]byte(`{"status":"some steps failed"}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("upstream down\n"))
+		}
+	}))
+	defer srv.Close()
+	c := newClient(settings{URL: srv.URL + "/", Token: "secret"})
+
+	tests := []struct {
+		path       string
+		wantStatus int // 0 for no error
+		wantMsg    string
+	}{
+		{path: "/ok"},
+		{path: "/conflict", wantStatus: http.StatusConflict, wantMsg: "busy"},
+		{path: "/partial", wantStatus: http.StatusMultiStatus, wantMsg: "some steps failed"},
+		{path: "/other", wantStatus: http.StatusBadGateway, wantMsg: "upstream down"},
+	}
+	for _, tt := range tests {
+		body, err := c.do(http.MethodGet, tt.path, nil)
+		if len(body) == 0 {
+			t.Errorf("%s: no body returned", tt.path)
+		}
+		var apiErr *apiError
+		if tt.wantStatus == 0 {
+			if err != nil {
+				t.Errorf("%s: %v", tt.path, err)
+			}
+			continue
+		}
+		if !errors.As(err, &apiErr) || apiErr.Status != tt.wantStatus || apiErr.Message != tt.wantMsg {
+			t.Errorf("%s: error = %v, want %d %q", tt.path, err, tt.wantStatus, tt.wantMsg)
+		}
+	}
+}
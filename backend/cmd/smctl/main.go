@@ -0,0 +1,620 @@
+// smctl is a command-line client for the server manager API, intended for
+// shell scripts and deployment tooling.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitTimeout = 3
+)
+
+const usage = `Usage: smctl [flags] <command> [args]
+
+Commands:
+  status [id...]                       show process status
+  start <id...> | --all | --group G    start processes
+  stop <id...> | --all | --group G     stop processes
+  restart <id...> | --all              restart processes
+  scale <group> <replicas>             change the number of replicas of a group
+  logs [-f] [-n lines] <id>            print (and follow) a process log
+  events [-f] [--process id]           print (and follow) the event timeline
+  config get                           print config.json
+  config set <file|->                  replace config.json
+  config validate <file|->             validate a config without applying it
+  wait <id> --state S [--timeout D]    wait until a process reaches state S
+
+Flags:
+`
+
+// ProcessStatus mirrors the fields of the server's status payload used here.
+type ProcessStatus struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	State        string  `json:"state"`
+	PID          int32   `json:"pid"`
+	CPU          float64 `json:"cpu"`
+	MemoryMB     float64 `json:"memory_mb"`
+	StartedAt    int64   `json:"started_at"`
+	RestartCount int     `json:"restart_count"`
+	AutoRestart  bool    `json:"auto_restart"`
+	Category     string  `json:"category"`
+}
+
+// Operation mirrors the server's asynchronous operation payload.
+type Operation struct {
+	ID    string `json:"id"`
+	State string `json:"state"`
+	Steps []struct {
+		ProcessID string `json:"process_id"`
+		Action    string `json:"action"`
+		State     string `json:"state"`
+		Error     string `json:"error"`
+	} `json:"steps"`
+}
+
+type Event struct {
+	TimestampMS int64  `json:"timestamp_ms"`
+	ProcessID   string `json:"process_id"`
+	ProcessName string `json:"process_name"`
+	Type        string `json:"type"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+type app struct {
+	c      *client
+	json   bool
+	stdout io.Writer
+}
+
+// usageError marks errors that should exit with exitUsage.
+type usageError struct{ msg string }
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...any) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+var errTimeout = errors.New("timed out")
+
+// processStates are the states wait can wait for.
+var processStates = []string{"running", "stopped", "crashed", "stopping"}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	fs := flag.NewFlagSet("smctl", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", defaultConfigPath(), "client config file (env SMCTL_CONFIG)")
+	urlFlag := fs.String("url", "", "server URL (env SMCTL_URL, default http://localhost:8090)")
+	token := fs.String("token", "", "bearer token (env SMCTL_TOKEN)")
+	unixSocket := fs.String("unix-socket", "", "connect over a Unix domain socket (env SMCTL_UNIX_SOCKET)")
+	insecure := fs.Bool("insecure", false, "skip TLS certificate verification (env SMCTL_INSECURE=1)")
+	output := fs.String("o", "table", "output format: table or json")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintln(os.Stderr, "smctl: -o must be table or json")
+		return exitUsage
+	}
+
+	s, err := loadSettings(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "smctl:", err)
+		return exitUsage
+	}
+	if *urlFlag != "" {
+		s.URL = *urlFlag
+	}
+	if *token != "" {
+		s.Token = *token
+	}
+	if *unixSocket != "" {
+		s.UnixSocket = *unixSocket
+	}
+	if *insecure {
+		s.Insecure = true
+	}
+
+	a := &app{c: newClient(s), json: *output == "json", stdout: os.Stdout}
+	cmd, rest := fs.Arg(0), fs.Args()[1:]
+
+	var cmdErr error
+	switch cmd {
+	case "status":
+		cmdErr = a.status(rest)
+	case "start", "stop", "restart":
+		cmdErr = a.lifecycle(cmd, rest)
+	case "scale":
+		cmdErr = a.scale(rest)
+	case "logs":
+		cmdErr = a.logs(rest)
+	case "events":
+		cmdErr = a.events(rest)
+	case "config":
+		cmdErr = a.config(rest)
+	case "wait":
+		cmdErr = a.wait(rest)
+	default:
+		cmdErr = usagef("unknown command %q", cmd)
+	}
+
+	var ue *usageError
+	switch {
+	case cmdErr == nil:
+		return exitOK
+	case errors.As(cmdErr, &ue):
+		fmt.Fprintln(os.Stderr, "smctl:", cmdErr)
+		fs.Usage()
+		return exitUsage
+	case errors.Is(cmdErr, errTimeout):
+		fmt.Fprintln(os.Stderr, "smctl:", cmdErr)
+		return exitTimeout
+	default:
+		fmt.Fprintln(os.Stderr, "smctl:", cmdErr)
+		return exitFailure
+	}
+}
+
+// ── status ───────────────────────────────────────────────────────────────────
+
+func (a *app) fetchStatuses() ([]ProcessStatus, []byte, error) {
+	var statuses []ProcessStatus
+	raw, err := a.c.getJSON("/api/processes", &statuses)
+	return statuses, raw, err
+}
+
+func (a *app) status(args []string) error {
+	statuses, raw, err := a.fetchStatuses()
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		want := make(map[string]bool, len(args))
+		for _, id := range args {
+			want[id] = true
+		}
+		filtered := statuses[:0]
+		for _, s := range statuses {
+			if want[s.ID] {
+				filtered = append(filtered, s)
+				delete(want, s.ID)
+			}
+		}
+		for id := range want {
+			return fmt.Errorf("process not found: %s", id)
+		}
+		statuses = filtered
+		raw, _ = json.Marshal(statuses)
+	}
+	if a.json {
+		return a.printJSON(raw)
+	}
+
+	tw := tabwriter.NewWriter(a.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tSTATE\tPID\tCPU%\tMEM(MB)\tUPTIME\tRESTARTS\tAUTO-RESTART")
+	for _, s := range statuses {
+		uptime := "-"
+		if s.StartedAt > 0 {
+			uptime = time.Since(time.UnixMilli(s.StartedAt)).Truncate(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.1f\t%.1f\t%s\t%d\t%t\n",
+			s.ID, s.Name, s.State, s.PID, s.CPU, s.MemoryMB, uptime, s.RestartCount, s.AutoRestart)
+	}
+	return tw.Flush()
+}
+
+// ── start / stop / restart ───────────────────────────────────────────────────
+
+func (a *app) lifecycle(action string, args []string) error {
+	fs := flag.NewFlagSet(action, flag.ContinueOnError)
+	all := fs.Bool("all", false, "apply to every process")
+	group := ""
+	if action != "restart" {
+		fs.StringVar(&group, "group", "", "apply to every replica of a group")
+	}
+	if err := fs.Parse(args); err != nil {
+		return usagef("%v", err)
+	}
+	ids := fs.Args()
+	selectors := 0
+	for _, set := range []bool{*all, group != "", len(ids) > 0} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors != 1 {
+		if action == "restart" {
+			return usagef("%s needs process IDs or --all", action)
+		}
+		return usagef("%s needs process IDs, --all or --group", action)
+	}
+
+	paths := make([]string, 0, len(ids))
+	if *all {
+		paths = append(paths, "/api/processes/"+action+"-all")
+	}
+	if group != "" {
+		paths = append(paths, "/api/groups/"+url.PathEscape(group)+"/"+action)
+	}
+	for _, id := range ids {
+		paths = append(paths, processPath(id, action))
+	}
+
+	// A rejected request, such as a 409 for a busy process, doesn't stop
+	// the remaining ones from being submitted
+	failed, total, rejected := 0, 0, 0
+	for _, p := range paths {
+		op, err := a.runOperation(p, nil)
+		if err != nil {
+			rejected++
+			fmt.Fprintf(os.Stderr, "smctl: %s: %v\n", p, err)
+			continue
+		}
+		failed += a.reportSteps(action, op)
+		total += len(op.Steps)
+	}
+	switch {
+	case rejected > 0 && failed > 0:
+		return fmt.Errorf("%d of %d requests rejected, %d of %d processes failed", rejected, len(paths), failed, total)
+	case rejected > 0:
+		return fmt.Errorf("%d of %d requests rejected", rejected, len(paths))
+	case failed > 0:
+		return fmt.Errorf("%d of %d processes failed", failed, total)
+	}
+	return nil
+}
+
+// reportSteps prints each step's outcome, returning the number that failed.
+func (a *app) reportSteps(action string, op *Operation) (failed int) {
+	for _, st := range op.Steps {
+		if st.State != "succeeded" {
+			failed++
+			fmt.Fprintf(os.Stderr, "smctl: %s %s: %s %s\n", action, st.ProcessID, st.State, st.Error)
+		} else if !a.json {
+			fmt.Fprintf(a.stdout, "%s: %s ok\n", st.ProcessID, action)
+		}
+	}
+	return failed
+}
+
+// ── scale ────────────────────────────────────────────────────────────────────
+
+func (a *app) scale(args []string) error {
+	if len(args) != 2 {
+		return usagef("scale needs a group and a replica count")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 {
+		return usagef("invalid replica count %q", args[1])
+	}
+	body, _ := json.Marshal(map[string]int{"replicas": n})
+	op, err := a.runOperation("/api/groups/"+url.PathEscape(args[0])+"/scale", body)
+	if err != nil {
+		return err
+	}
+	// Steps are "start" for added replicas and "remove" for surplus ones
+	failed := 0
+	for _, st := range op.Steps {
+		if st.State != "succeeded" {
+			failed++
+			fmt.Fprintf(os.Stderr, "smctl: %s %s: %s %s\n", st.Action, st.ProcessID, st.State, st.Error)
+		} else if !a.json {
+			fmt.Fprintf(a.stdout, "%s: %s ok\n", st.ProcessID, st.Action)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replicas failed", failed, len(op.Steps))
+	}
+	if !a.json {
+		fmt.Fprintf(a.stdout, "%s scaled to %d\n", args[0], n)
+	}
+	return nil
+}
+
+// runOperation submits a lifecycle request and polls the resulting
+// operation until it finishes.
+func (a *app) runOperation(path string, body []byte) (*Operation, error) {
+	raw, err := a.c.do(http.MethodPost, path, body)
+	if err != nil {
+		return nil, err
+	}
+	var op Operation
+	if err := json.Unmarshal(raw, &op); err != nil {
+		return nil, err
+	}
+	for op.State == "pending" || op.State == "running" {
+		time.Sleep(250 * time.Millisecond)
+		if raw, err = a.c.getJSON("/api/operations/"+url.PathEscape(op.ID), &op); err != nil {
+			return nil, err
+		}
+	}
+	if a.json {
+		a.printJSON(raw)
+	}
+	return &op, nil
+}
+
+func processPath(id, suffix string) string {
+	return "/api/processes/" + url.PathEscape(id) + "/" + suffix
+}
+
+// ── logs ─────────────────────────────────────────────────────────────────────
+
+func (a *app) logs(args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	follow := fs.Bool("f", false, "follow new output")
+	lines := fs.Int("n", 30, "number of lines to show (1-500)")
+	interval := fs.Duration("interval", time.Second, "poll interval when following")
+	if err := fs.Parse(args); err != nil {
+		return usagef("%v", err)
+	}
+	if fs.NArg() != 1 {
+		return usagef("logs needs exactly one process ID")
+	}
+	id := fs.Arg(0)
+
+	// Following always fetches the maximum tail so gaps between polls are
+	// unlikely; the first print is trimmed to the requested line count.
+	tail := *lines
+	if *follow {
+		tail = 500
+	}
+
+	var prev []string
+	for first := true; ; first = false {
+		var body struct {
+			Lines []string `json:"lines"`
+		}
+		if _, err := a.c.getJSON(fmt.Sprintf("%s?tail=%d", processPath(id, "logs"), tail), &body); err != nil {
+			return err
+		}
+
+		fresh := newLines(prev, body.Lines)
+		if first && len(fresh) > *lines {
+			fresh = fresh[len(fresh)-*lines:]
+		}
+		for _, l := range fresh {
+			if a.json {
+				out, _ := json.Marshal(map[string]string{"process_id": id, "line": l})
+				fmt.Fprintln(a.stdout, string(out))
+			} else {
+				fmt.Fprintln(a.stdout, l)
+			}
+		}
+		prev = body.Lines
+
+		if !*follow {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// newLines returns the suffix of cur that was not already in prev, by
+// finding the longest tail of prev that is a prefix of cur.
+func newLines(prev, cur []string) []string {
+	for k := min(len(prev), len(cur)); k > 0; k-- {
+		match := true
+		for i := 0; i < k; i++ {
+			if prev[len(prev)-k+i] != cur[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return cur[k:]
+		}
+	}
+	return cur
+}
+
+// ── events ───────────────────────────────────────────────────────────────────
+
+func (a *app) events(args []string) error {
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	follow := fs.Bool("f", false, "follow new events")
+	process := fs.String("process", "", "only show events for this process ID")
+	interval := fs.Duration("interval", 2*time.Second, "poll interval when following")
+	if err := fs.Parse(args); err != nil {
+		return usagef("%v", err)
+	}
+
+	var cursor eventCursor
+	for {
+		var events []Event
+		if _, err := a.c.getJSON("/api/events", &events); err != nil {
+			return err
+		}
+		for _, ev := range cursor.next(events) {
+			if *process != "" && ev.ProcessID != *process {
+				continue
+			}
+			if a.json {
+				out, _ := json.Marshal(ev)
+				fmt.Fprintln(a.stdout, string(out))
+			} else {
+				ts := time.UnixMilli(ev.TimestampMS).Format("2006-01-02 15:04:05")
+				fmt.Fprintf(a.stdout, "%s  %-10s %s (%s)\n", ts, ev.Type, ev.ProcessName, ev.ProcessID)
+			}
+		}
+
+		if !*follow {
+			return nil
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// eventCursor tracks which events have been printed while following. Events
+// have no ID, so it remembers the ones seen from the newest millisecond: a
+// crash and its auto-restart can share one, and the second may only show up
+// on the next poll.
+type eventCursor struct {
+	lastTS int64
+	seen   map[Event]bool // events at lastTS
+}
+
+// next returns the events, oldest first, that earlier calls have not.
+func (c *eventCursor) next(events []Event) []Event {
+	cutoff, before := c.lastTS, c.seen
+	var fresh []Event
+	for _, ev := range events {
+		if ev.TimestampMS < cutoff || before[ev] {
+			continue
+		}
+		if ev.TimestampMS > c.lastTS || c.seen == nil {
+			c.lastTS, c.seen = ev.TimestampMS, make(map[Event]bool)
+		}
+		if ev.TimestampMS == c.lastTS {
+			c.seen[ev] = true
+		}
+		fresh = append(fresh, ev)
+	}
+	return fresh
+}
+
+// ── config ───────────────────────────────────────────────────────────────────
+
+func (a *app) config(args []string) error {
+	if len(args) == 0 {
+		return usagef("config needs a subcommand: get, set or validate")
+	}
+	switch args[0] {
+	case "get":
+		raw, err := a.c.do(http.MethodGet, "/api/config", nil)
+		if err != nil {
+			return err
+		}
+		_, err = a.stdout.Write(raw)
+		return err
+	case "set", "validate":
+		if len(args) != 2 {
+			return usagef("config %s needs a file argument (or - for stdin)", args[0])
+		}
+		data, err := readInput(args[1])
+		if err != nil {
+			return err
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("%s is not valid JSON", args[1])
+		}
+		method, path := http.MethodPut, "/api/config"
+		if args[0] == "validate" {
+			method, path = http.MethodPost, "/api/config/validate"
+		}
+		raw, err := a.c.do(method, path, data)
+		if err != nil {
+			return err
+		}
+		if a.json {
+			return a.printJSON(raw)
+		}
+		fmt.Fprintf(a.stdout, "config %s ok\n", map[string]string{"set": "updated", "validate": "valid"}[args[0]])
+		return nil
+	default:
+		return usagef("unknown config subcommand %q", args[0])
+	}
+}
+
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
+
+// ── wait ─────────────────────────────────────────────────────────────────────
+
+func (a *app) wait(args []string) error {
+	// Allow the process ID before or after the flags.
+	var id string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		id, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet("wait", flag.ContinueOnError)
+	state := fs.String("state", "running", "state to wait for (running, stopped, crashed, stopping)")
+	timeout := fs.Duration("timeout", time.Minute, "give up after this long")
+	interval := fs.Duration("interval", time.Second, "poll interval")
+	if err := fs.Parse(args); err != nil {
+		return usagef("%v", err)
+	}
+	if id == "" && fs.NArg() == 1 {
+		id = fs.Arg(0)
+	}
+	if id == "" {
+		return usagef("wait needs a process ID")
+	}
+	if !slices.Contains(processStates, *state) {
+		return usagef("--state must be one of %s", strings.Join(processStates, ", "))
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for {
+		statuses, _, err := a.fetchStatuses()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, s := range statuses {
+			if s.ID != id {
+				continue
+			}
+			found = true
+			if s.State == *state {
+				if a.json {
+					out, _ := json.Marshal(s)
+					return a.printJSON(out)
+				}
+				fmt.Fprintf(a.stdout, "%s is %s\n", id, s.State)
+				return nil
+			}
+		}
+		if !found {
+			return fmt.Errorf("process not found: %s", id)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not reach state %s within %s: %w", id, *state, *timeout, errTimeout)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func (a *app) printJSON(raw []byte) error {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		_, err = a.stdout.Write(raw)
+		return err
+	}
+	enc := json.NewEncoder(a.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
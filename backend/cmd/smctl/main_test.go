@@ -0,0 +1,148 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeServer answers the requests the run tests make and records the
+// lifecycle requests it was sent.
+func fakeServer(t *testing.T) (url string, posted func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var paths []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/processes", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id":"web","state":"running"}]`))
+	})
+	mux.HandleFunc("POST /api/processes/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		switch r.PathValue("id") {
+		case "busy":
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error":"an operation is already in progress"}`))
+		case "broken":
+			w.Write([]byte(`{"id":"op2","state":"failed","steps":[{"process_id":"broken","action":"start","state":"failed","error":"exec failed"}]}`))
+		default:
+			w.Write([]byte(`{"id":"op1","state":"succeeded","steps":[{"process_id":"` + r.PathValue("id") + `","action":"start","state":"succeeded"}]}`))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string{}, paths...)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	for _, env := range []string{"SMCTL_CONFIG", "SMCTL_URL", "SMCTL_TOKEN", "SMCTL_UNIX_SOCKET", "SMCTL_INSECURE"} {
+		t.Setenv(env, "")
+	}
+	url, _ := fakeServer(t)
+	noConfig := filepath.Join(t.TempDir(), "smctl.json")
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no command", nil, exitUsage},
+		{"unknown command", []string{"frobnicate"}, exitUsage},
+		{"unknown flag", []string{"-bogus", "status"}, exitUsage},
+		{"bad output format", []string{"-o", "yaml", "status"}, exitUsage},
+		{"status", []string{"status"}, exitOK},
+		{"status json", []string{"-o", "json", "status", "web"}, exitOK},
+		{"start without targets", []string{"start"}, exitUsage},
+		{"restart with a group", []string{"restart", "--group", "g"}, exitUsage},
+		{"start succeeds", []string{"start", "web"}, exitOK},
+		{"start rejected", []string{"start", "busy"}, exitFailure},
+		{"start step fails", []string{"start", "broken"}, exitFailure},
+		{"scale without a count", []string{"scale", "g"}, exitUsage},
+		{"logs without an id", []string{"logs"}, exitUsage},
+		{"config without a subcommand", []string{"config"}, exitUsage},
+		{"wait without an id", []string{"wait", "--state", "running"}, exitUsage},
+		{"wait for a misspelt state", []string{"wait", "web", "--state", "runing"}, exitUsage},
+		{"wait for the current state", []string{"wait", "web", "--state", "running"}, exitOK},
+		{"wait with the id last", []string{"wait", "--state", "running", "web"}, exitOK},
+		{"wait times out", []string{"wait", "web", "--state", "stopped", "--timeout", "0", "--interval", "1ms"}, exitTimeout},
+		{"wait for an unknown process", []string{"wait", "ghost"}, exitFailure},
+	}
+	for _, tt := range tests {
+		args := append([]string{"-config", noConfig, "-url", url}, tt.args...)
+		if got := run(args); got != tt.want {
+			t.Errorf("%s: smctl %v exited %d, want %d", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestLifecycleContinuesAfterRejection(t *testing.T) {
+	t.Setenv("SMCTL_URL", "")
+	url, posted := fakeServer(t)
+	noConfig := filepath.Join(t.TempDir(), "smctl.json")
+
+	if got := run([]string{"-config", noConfig, "-url", url, "start", "busy", "web", "broken"}); got != exitFailure {
+		t.Errorf("exit code = %d, want %d", got, exitFailure)
+	}
+	want := []string{"/api/processes/busy/start", "/api/processes/web/start", "/api/processes/broken/start"}
+	if got := posted(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestNewLines(t *testing.T) {
+	tests := []struct {
+		name      string
+		prev, cur []string
+		want      []string
+	}{
+		{"first poll", nil, []string{"a", "b"}, []string{"a", "b"}},
+		{"nothing new", []string{"a", "b"}, []string{"a", "b"}, []string{}},
+		{"appended", []string{"a", "b"}, []string{"a", "b", "c"}, []string{"c"}},
+		{"window moved", []string{"a", "b", "c"}, []string{"b", "c", "d", "e"}, []string{"d", "e"}},
+		{"no overlap", []string{"a", "b"}, []string{"x", "y"}, []string{"x", "y"}},
+		{"repeated lines", []string{"x", "x"}, []string{"x", "x", "x"}, []string{"x"}},
+		{"log truncated", []string{"a", "b", "c"}, []string{"d"}, []string{"d"}},
+		{"empty log", []string{"a"}, nil, nil},
+	}
+	for _, tt := range tests {
+		got := newLines(tt.prev, tt.cur)
+		if len(got) != len(tt.want) || (len(got) > 0 && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("%s: newLines(%v, %v) = %v, want %v", tt.name, tt.prev, tt.cur, got, tt.want)
+		}
+	}
+}
+
+func TestEventCursor(t *testing.T) {
+	crash := Event{TimestampMS: 100, ProcessID: "web", Type: "crashed", Reason: "exit_code_1"}
+	restart := Event{TimestampMS: 100, ProcessID: "web", Type: "started"}
+	other := Event{TimestampMS: 100, ProcessID: "db", Type: "started"}
+	later := Event{TimestampMS: 200, ProcessID: "web", Type: "stopped"}
+	earlier := Event{TimestampMS: 50, ProcessID: "db", Type: "stopped"}
+
+	// Each poll returns the server's whole buffer, oldest first
+	polls := []struct {
+		events []Event
+		want   []Event
+	}{
+		{[]Event{earlier, crash}, []Event{earlier, crash}},
+		{[]Event{earlier, crash, restart}, []Event{restart}}, // same millisecond as crash
+		{[]Event{earlier, crash, restart, other}, []Event{other}},
+		{[]Event{earlier, crash, restart, other}, nil},
+		{[]Event{earlier, crash, restart, other, later}, []Event{later}},
+		{[]Event{crash, restart, other, later}, nil}, // oldest dropped from the buffer
+	}
+	var c eventCursor
+	for i, p := range polls {
+		if got := c.next(p.events); !reflect.DeepEqual(got, p.want) {
+			t.Errorf("poll %d: got %v, want %v", i+1, got, p.want)
+		}
+	}
+}
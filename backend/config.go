@@ -7,30 +7,46 @@ import (
 )
 
 type ProcessConfig struct {
-	ID              string   `json:"id"`
-	Name            string   `json:"name"`
-	Executable      string   `json:"executable"`
-	Args            []string `json:"args"`
-	WorkingDir      string   `json:"working_dir"`
-	AutoRestart     bool     `json:"auto_restart"`
-	IsService       bool     `json:"is_service"`
-	ServiceName     string   `json:"service_name"`
-	Category        string   `json:"category"`
-	ShutdownDelay   int      `json:"shutdown_delay"`
-	LogMaxSizeMB    int      `json:"log_max_size_mb"`
-	LogMaxBackups   int      `json:"log_max_backups"`
-	LogMaxAgeDays   int      `json:"log_max_age_days"`
+	ID                  string            `json:"id"`
+	Name                string            `json:"name"`
+	Executable          string            `json:"executable"`
+	Args                []string          `json:"args"`
+	WorkingDir          string            `json:"working_dir"`
+	AutoRestart         bool              `json:"auto_restart"`
+	IsService           bool              `json:"is_service"`
+	ServiceName         string            `json:"service_name"`
+	Category            string            `json:"category"`
+	ShutdownDelay       int               `json:"shutdown_delay"`
+	LogMaxSizeMB        int               `json:"log_max_size_mb"`
+	LogMaxBackups       int               `json:"log_max_backups"`
+	LogMaxAgeDays       int               `json:"log_max_age_days"`
+	Env                 map[string]string `json:"env,omitempty"`
+	EnvFiles            []string          `json:"env_files,omitempty"`
+	InheritEnv          *InheritEnv       `json:"inherit_env,omitempty"`
+	Limits              *ResourceLimits   `json:"limits,omitempty"`
+	User                string            `json:"user,omitempty"`
+	Group               string            `json:"group,omitempty"`
+	SupplementaryGroups []string          `json:"supplementary_groups,omitempty"`
+	Umask               string            `json:"umask,omitempty"` // octal, e.g. "027"
+	Chroot              string            `json:"chroot,omitempty"`
+	NoNewPrivileges     bool              `json:"no_new_privileges,omitempty"`
+	Replicas            *int              `json:"replicas,omitempty"`
+	Instances           []InstanceConfig  `json:"instances,omitempty"`
+	Ports               map[string]int    `json:"ports,omitempty"`
+	Source              string            `json:"source,omitempty"` // included file it is defined in; empty for the main config
+	ReplicaOf           string            `json:"-"`                // definition ID, set on expanded replicas
+	Replica             int               `json:"-"`                // replica number, from 1
 }
 
 type Config struct {
 	Processes []ProcessConfig `json:"processes"`
+	Auth      *AuthConfig     `json:"auth,omitempty"`
+	Server    *ServerConfig   `json:"server,omitempty"`
+	Host      *HostConfig     `json:"host,omitempty"`
+	Include   []string        `json:"include,omitempty"` // glob patterns of files with more processes
 }
 
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
+func parseConfig(data []byte) (*Config, error) {
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
@@ -38,14 +54,6 @@ func loadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
-func (cfg *Config) saveConfig(path string) error {
-	data, err := json.MarshalIndent(cfg, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(path, data, 0644)
-}
-
 // rotateLog handles log file rotation based on size and age.
 // Returns the log file path to use.
 func rotateLog(logPath string, maxSizeMB, maxBackups, maxAgeDays int) (string, error) {
@@ -71,7 +79,7 @@ func rotateLog(logPath string, maxSizeMB, maxBackups, maxAgeDays int) (string, e
 	// Find the next backup number
 	nextNum := 1
 	for i := 1; i <= maxBackups; i++ {
-		backupPath := logPath + "." + string(rune('0' + i))
+		backupPath := logPath + "." + string(rune('0'+i))
 		if _, err := os.Stat(backupPath); os.IsNotExist(err) {
 			nextNum = i
 			break
@@ -83,8 +91,8 @@ func rotateLog(logPath string, maxSizeMB, maxBackups, maxAgeDays int) (string, e
 
 	// Shift existing backups: .5 -> .6 (remove .6), .4 -> .5, etc.
 	for i := nextNum; i > 1; i-- {
-		oldPath := logPath + "." + string(rune('0' + i - 1))
-		newPath := logPath + "." + string(rune('0' + i))
+		oldPath := logPath + "." + string(rune('0'+i-1))
+		newPath := logPath + "." + string(rune('0'+i))
 		os.Rename(oldPath, newPath) // Ignore error if old doesn't exist
 	}
 
@@ -98,7 +106,7 @@ func rotateLog(logPath string, maxSizeMB, maxBackups, maxAgeDays int) (string, e
 	if maxAgeDays > 0 {
 		now := time.Now()
 		for i := 1; i <= maxBackups; i++ {
-			checkPath := logPath + "." + string(rune('0' + i))
+			checkPath := logPath + "." + string(rune('0'+i))
 			if info, err := os.Stat(checkPath); err == nil {
 				age := now.Sub(info.ModTime()).Hours() / 24
 				if age > float64(maxAgeDays) {
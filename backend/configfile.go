@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const configLockTimeout = 5 * time.Second
+
+const EventConfigRecovered = "config_recovered"
+
+// configFileMu serialises config writers within this process; the lock file
+// serialises them across processes.
+var configFileMu sync.Mutex
+
+// errConfigLocked is returned when another process holds the config lock for
+// longer than configLockTimeout.
+var errConfigLocked = errors.New("config file is locked by another process")
+
+// lockConfigFile takes the writer lock for the config at path, held on a
+// separate .lock file so that renaming the config does not drop it.
+func lockConfigFile(path string) (unlock func(), err error) {
+	configFileMu.Lock()
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		configFileMu.Unlock()
+		return nil, err
+	}
+	deadline := time.Now().Add(configLockTimeout)
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			configFileMu.Unlock()
+			return nil, err
+		}
+		if locked {
+			break
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			configFileMu.Unlock()
+			return nil, errConfigLocked
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+		configFileMu.Unlock()
+	}, nil
+}
+
+// writeFileAtomic replaces path with data so that readers, and the file
+// after a crash, see either the old contents or the new ones in full.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// Make the rename itself durable. Directories can't be synced on
+	// Windows, where the error is ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// configFileMode returns the permission bits of the file at path, or 0644
+// if it does not exist yet, so a rewrite keeps an admin's chmod 600.
+func configFileMode(path string) os.FileMode {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.Mode().Perm()
+	}
+	return 0644
+}
+
+// backupConfig copies the file at path to path.bak before it is replaced.
+// A file that does not parse is not backed up, so the backup always holds
+// the last good version. The backup gets the file's permissions.
+func backupConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := configToJSON(configFormat(path), data); err != nil {
+		return nil
+	}
+	return writeFileAtomic(path+".bak", data, configFileMode(path))
+}
+
+// recoverConfigFile restores a file that does not parse from its backup,
+// keeping the broken file next to it. It returns what happened, for the
+// event log.
+func recoverConfigFile(f *configFile, loadErr error) (string, error) {
+	bak, err := os.ReadFile(f.path + ".bak")
+	if err != nil {
+		return "", fmt.Errorf("%w (no usable backup: %v)", loadErr, err)
+	}
+	if _, err := configToJSON(f.format, bak); err != nil {
+		return "", fmt.Errorf("%w (backup is corrupt too: %v)", loadErr, err)
+	}
+
+	mode := configFileMode(f.path)
+	corrupt := fmt.Sprintf("%s.corrupt-%s", f.path, time.Now().Format("20060102-150405"))
+	if err := writeFileAtomic(corrupt, f.data, mode); err != nil {
+		return "", fmt.Errorf("%w (could not set it aside: %v)", loadErr, err)
+	}
+	if err := writeFileAtomic(f.path, bak, mode); err != nil {
+		return "", fmt.Errorf("%w (could not restore backup: %v)", loadErr, err)
+	}
+	f.data = bak
+	msg := fmt.Sprintf("%s was corrupt (%v); restored %s.bak and kept the corrupt file as %s", f.path, loadErr, f.path, corrupt)
+	log.Printf("[config] WARNING: %s", msg)
+	return msg, nil
+}
+
+// ── Config files ─────────────────────────────────────────────────────────────
+
+// configFile is one file the config was loaded from: the main config, or a
+// file matched by its include patterns.
+type configFile struct {
+	path   string
+	name   string // relative to the main config's directory; a process's source
+	format string
+	data   []byte // contents as last loaded or written
+}
+
+// includedDoc is the content of an included file.
+type includedDoc struct {
+	Processes []ProcessConfig `json:"processes"`
+}
+
+// readConfigFile reads and decodes one file. With recover, a file that does
+// not parse is restored from its backup first.
+func readConfigFile(f *configFile, included, recover bool, recovered *[]string) (*Config, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	f.data = data
+	cfg, err := parseConfigFile(f, included)
+	if err == nil || !recover {
+		return cfg, err
+	}
+	msg, err := recoverConfigFile(f, err)
+	if err != nil {
+		return nil, err
+	}
+	*recovered = append(*recovered, msg)
+	return parseConfigFile(f, included)
+}
+
+func parseConfigFile(f *configFile, included bool) (*Config, error) {
+	data, err := configToJSON(f.format, f.data)
+	if err == nil && included {
+		err = checkIncludedDoc(data)
+	}
+	var cfg *Config
+	if err == nil {
+		cfg, err = parseConfig(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", f.name, err)
+	}
+	return cfg, nil
+}
+
+// includedPaths expands include patterns, relative to the main config's
+// directory, into the files they match, in order and without repeats.
+// Backups and temporary files are skipped.
+func includedPaths(mainPath string, patterns []string) ([]string, error) {
+	dir := filepath.Dir(mainPath)
+	mainAbs, _ := filepath.Abs(mainPath)
+	seen := map[string]bool{mainAbs: true}
+	var paths []string
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("include %q: %w", pattern, err)
+		}
+		for _, m := range matches {
+			abs, _ := filepath.Abs(m)
+			base := filepath.Base(m)
+			if seen[abs] || strings.HasPrefix(base, ".") || strings.HasSuffix(base, ".bak") ||
+				strings.HasSuffix(base, ".lock") || strings.Contains(base, ".corrupt-") {
+				continue
+			}
+			if info, err := os.Stat(m); err != nil || info.IsDir() {
+				continue
+			}
+			seen[abs] = true
+			paths = append(paths, m)
+		}
+	}
+	return paths, nil
+}
+
+// loadConfigFiles reads the main config at path and the files it includes,
+// merging their processes into one Config. Processes from included files
+// have their file's name as Source. With recover, files that don't parse
+// are restored from their backups, and what was done is returned.
+func loadConfigFiles(path string, recover bool) (cfg *Config, files []configFile, recovered []string, err error) {
+	dir := filepath.Dir(path)
+	main := configFile{path: path, name: filepath.ToSlash(filepath.Base(path)), format: configFormat(path)}
+	cfg, err = readConfigFile(&main, false, recover, &recovered)
+	if err != nil {
+		return nil, nil, recovered, err
+	}
+	files = []configFile{main}
+
+	owners := make(map[string]string, len(cfg.Processes))
+	for i := range cfg.Processes {
+		cfg.Processes[i].Source = ""
+		owners[cfg.Processes[i].ID] = main.name
+	}
+	paths, err := includedPaths(path, cfg.Include)
+	if err != nil {
+		return nil, nil, recovered, err
+	}
+	for _, p := range paths {
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			name = p
+		}
+		f := configFile{path: p, name: filepath.ToSlash(name), format: configFormat(p)}
+		inc, err := readConfigFile(&f, true, recover, &recovered)
+		if err != nil {
+			return nil, nil, recovered, err
+		}
+		for _, pc := range inc.Processes {
+			if owner, ok := owners[pc.ID]; ok {
+				return nil, nil, recovered, fmt.Errorf("%s: process %s is already defined in %s", f.name, pc.ID, owner)
+			}
+			owners[pc.ID] = f.name
+			pc.Source = f.name
+			cfg.Processes = append(cfg.Processes, pc)
+		}
+		files = append(files, f)
+	}
+	return cfg, files, recovered, nil
+}
+
+// sumConfigFiles hashes the names and contents of a set of config files.
+func sumConfigFiles(files []configFile) [32]byte {
+	h := sha256.New()
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		h.Write([]byte(f.name))
+		h.Write([]byte{0})
+		h.Write(sum[:])
+	}
+	var out [32]byte
+	h.Sum(out[:0])
+	return out
+}
+
+// configStateSum hashes the config files as they are on disk now. If they
+// don't load, the main file alone is hashed, so the sum still changes with
+// every edit.
+func configStateSum(path string) ([32]byte, error) {
+	_, files, _, err := loadConfigFiles(path, false)
+	if err != nil {
+		data, rerr := os.ReadFile(path)
+		if rerr != nil {
+			return [32]byte{}, rerr
+		}
+		files = []configFile{{name: filepath.Base(path), data: data}}
+	}
+	return sumConfigFiles(files), nil
+}
+
+// saveConfigFiles writes cfg back to the files it was loaded from: each
+// process to its source file and everything else to the main config. Files
+// whose content would not change are left alone, and each one written is
+// replaced atomically with a backup. It returns the files as written. The
+// caller must hold the config file lock.
+func saveConfigFiles(cfg *Config, files []configFile) ([]configFile, error) {
+	bySource := make(map[string][]ProcessConfig, len(files))
+	for _, pc := range cfg.Processes {
+		src := pc.Source
+		pc.Source = ""
+		bySource[src] = append(bySource[src], pc)
+	}
+
+	written := slices.Clone(files)
+	for i := range written {
+		f := &written[i]
+		var doc, prev any
+		if i == 0 {
+			main := *cfg
+			main.Processes = append([]ProcessConfig{}, bySource[""]...)
+			doc, prev = &main, &Config{}
+		} else {
+			doc, prev = &includedDoc{Processes: append([]ProcessConfig{}, bySource[f.name]...)}, &includedDoc{}
+		}
+		if sameConfigContent(f, doc, prev) {
+			continue
+		}
+		data, err := encodeConfig(f.format, doc, f.data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		if err := backupConfig(f.path); err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", f.name, err)
+		}
+		if err := writeFileAtomic(f.path, data, configFileMode(f.path)); err != nil {
+			return nil, err
+		}
+		f.data = data
+	}
+	return written, nil
+}
+
+// sameConfigContent reports whether a file already holds doc. prev is an
+// empty value of doc's type to decode the file into.
+func sameConfigContent(f *configFile, doc, prev any) bool {
+	data, err := configToJSON(f.format, f.data)
+	if err != nil || json.Unmarshal(data, prev) != nil {
+		return false
+	}
+	// A file without processes holds the same as one with an empty list
+	switch p := prev.(type) {
+	case *Config:
+		if p.Processes == nil {
+			p.Processes = []ProcessConfig{}
+		}
+	case *includedDoc:
+		if p.Processes == nil {
+			p.Processes = []ProcessConfig{}
+		}
+	}
+	a, err1 := json.Marshal(doc)
+	b, err2 := json.Marshal(prev)
+	return err1 == nil && err2 == nil && bytes.Equal(a, b)
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats, chosen by file extension.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// defaultConfigNames are tried in order when the default config.json does
+// not exist.
+var defaultConfigNames = []string{"config.json", "config.yaml", "config.yml", "config.toml"}
+
+// findDefaultConfig returns the first of defaultConfigNames that exists, or
+// config.json if none does.
+func findDefaultConfig() string {
+	for _, name := range defaultConfigNames {
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return defaultConfigNames[0]
+}
+
+func configFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatJSON
+	}
+}
+
+// configToJSON converts a config document to JSON, so that every format is
+// decoded into Config through the same json tags and rules.
+func configToJSON(format string, data []byte) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		var doc any
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			doc = map[string]any{} // an empty file
+		}
+		return json.Marshal(doc)
+	case FormatTOML:
+		var doc map[string]any
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			return nil, err
+		}
+		return json.Marshal(doc)
+	default:
+		if !json.Valid(data) {
+			var v any
+			return nil, json.Unmarshal(data, &v) // for the error message
+		}
+		return data, nil
+	}
+}
+
+// encodeConfig renders doc, a value with json tags, in the given format.
+// old is the file's current content: YAML files keep their comments and
+// key order, and fields a file left out are not added with zero values.
+// TOML files are rewritten, losing comments.
+func encodeConfig(format string, doc any, old []byte) ([]byte, error) {
+	if format == FormatJSON {
+		return json.MarshalIndent(doc, "", "  ")
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatYAML {
+		var next yaml.Node
+		if err := yaml.Unmarshal(data, &next); err != nil {
+			return nil, err
+		}
+		clearYAMLStyle(&next)
+		// Merge into the old document, or an empty one for a new file, so
+		// unset fields are left out either way
+		prev := yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+		if len(old) > 0 {
+			var doc yaml.Node
+			if yaml.Unmarshal(old, &doc) == nil && len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+				prev = doc
+			}
+		}
+		mergeYAML(prev.Content[0], next.Content[0], topLevelDefaults)
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&prev); err != nil {
+			return nil, err
+		}
+		enc.Close()
+		return buf.Bytes(), nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	var prev any
+	if len(old) > 0 {
+		var m map[string]any
+		if toml.Unmarshal(old, &m) == nil {
+			prev = m
+		}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(pruneZero(tomlValue(v), prev, topLevelDefaults)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Keys that encoding/json always writes, even when zero, for the top level
+// of a config file and for a process entry. Only these are left out of a
+// rewritten file when they are empty and the file did not have them: an
+// omitempty field that is written at all was set on purpose, like
+// inherit_env: false or replicas: 0.
+var (
+	topLevelDefaults = mergeKeys(defaultKeys(reflect.TypeFor[Config]()), defaultKeys(reflect.TypeFor[includedDoc]()))
+	processDefaults  = defaultKeys(reflect.TypeFor[ProcessConfig]())
+)
+
+// defaultKeys returns the json names of t's fields without omitempty.
+func defaultKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" || slices.Contains(strings.Split(opts, ","), "omitempty") {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		keys[name] = true
+	}
+	return keys
+}
+
+func mergeKeys(a, b map[string]bool) map[string]bool {
+	for k := range b {
+		a[k] = true
+	}
+	return a
+}
+
+// childDefaults returns the prunable keys below key: those of a process
+// entry under "processes", none elsewhere.
+func childDefaults(key string) map[string]bool {
+	if key == "processes" {
+		return processDefaults
+	}
+	return nil
+}
+
+// ── YAML ─────────────────────────────────────────────────────────────────────
+
+// mergeYAML updates dst in place to hold the values in src, keeping dst's
+// comments and key order. Keys only in dst are dropped, and keys only in src
+// are added, except prune keys with empty values. Lists of mappings with an
+// "id" are matched by id.
+func mergeYAML(dst, src *yaml.Node, prune map[string]bool) {
+	if dst.Kind != src.Kind {
+		comments := [3]string{dst.HeadComment, dst.LineComment, dst.FootComment}
+		*dst = *src
+		dst.HeadComment, dst.LineComment, dst.FootComment = comments[0], comments[1], comments[2]
+		return
+	}
+	switch dst.Kind {
+	case yaml.ScalarNode:
+		if dst.Value != src.Value || dst.Tag != src.Tag {
+			dst.Value, dst.Tag, dst.Style = src.Value, src.Tag, 0
+		}
+	case yaml.MappingNode:
+		srcKeys := make(map[string]*yaml.Node, len(src.Content)/2)
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			srcKeys[src.Content[i].Value] = src.Content[i+1]
+		}
+		kept := dst.Content[:0]
+		seen := make(map[string]bool)
+		for i := 0; i+1 < len(dst.Content); i += 2 {
+			key := dst.Content[i].Value
+			v, ok := srcKeys[key]
+			if !ok {
+				continue
+			}
+			mergeYAML(dst.Content[i+1], v, childDefaults(key))
+			kept = append(kept, dst.Content[i], dst.Content[i+1])
+			seen[key] = true
+		}
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			key, v := src.Content[i], src.Content[i+1]
+			if seen[key.Value] || prune[key.Value] && emptyYAML(v) {
+				continue
+			}
+			if key.Value == "processes" {
+				pruneYAML(v)
+			}
+			kept = append(kept, key, v)
+		}
+		dst.Content = kept
+	case yaml.SequenceNode:
+		byID := make(map[string]*yaml.Node)
+		for _, item := range dst.Content {
+			if id := yamlID(item); id != "" {
+				byID[id] = item
+			}
+		}
+		merged := make([]*yaml.Node, len(src.Content))
+		for i, item := range src.Content {
+			if prev, ok := byID[yamlID(item)]; ok {
+				mergeYAML(prev, item, prune)
+				merged[i] = prev
+			} else if id := yamlID(item); id == "" && i < len(dst.Content) && yamlID(dst.Content[i]) == "" {
+				mergeYAML(dst.Content[i], item, prune)
+				merged[i] = dst.Content[i]
+			} else {
+				if prune != nil {
+					pruneYAML(&yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{item}})
+				}
+				merged[i] = item
+			}
+		}
+		dst.Content = merged
+	default:
+		*dst = *src
+	}
+}
+
+// yamlID returns the "id" of a mapping node, if it has one.
+func yamlID(n *yaml.Node) string {
+	if n.Kind != yaml.MappingNode {
+		return ""
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == "id" {
+			return n.Content[i+1].Value
+		}
+	}
+	return ""
+}
+
+// pruneYAML drops empty values of default keys from the process entries of
+// a new processes list.
+func pruneYAML(list *yaml.Node) {
+	for _, item := range list.Content {
+		if item.Kind != yaml.MappingNode {
+			continue
+		}
+		kept := item.Content[:0]
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			if !processDefaults[item.Content[i].Value] || !emptyYAML(item.Content[i+1]) {
+				kept = append(kept, item.Content[i], item.Content[i+1])
+			}
+		}
+		item.Content = kept
+	}
+}
+
+func emptyYAML(n *yaml.Node) bool {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		switch n.Tag {
+		case "!!str":
+			return n.Value == ""
+		case "!!int", "!!float":
+			return n.Value == "0"
+		case "!!bool":
+			return n.Value == "false"
+		case "!!null":
+			return true
+		}
+	case yaml.MappingNode, yaml.SequenceNode:
+		return len(n.Content) == 0
+	}
+	return false
+}
+
+// clearYAMLStyle drops the flow and quoting styles a node parsed from JSON
+// comes with, so it is written in block style.
+func clearYAMLStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		clearYAMLStyle(c)
+	}
+}
+
+// ── TOML ─────────────────────────────────────────────────────────────────────
+
+// tomlValue converts decoded JSON for the TOML encoder: numbers become
+// integers where they can, and nulls are dropped since TOML has none.
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+			} else {
+				v[k] = tomlValue(e)
+			}
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = tomlValue(e)
+		}
+		// Lists of tables must have the table type to be encoded as [[x]]
+		if len(v) > 0 && !slices.ContainsFunc(v, func(e any) bool { _, ok := e.(map[string]any); return !ok }) {
+			tables := make([]map[string]any, len(v))
+			for i, e := range v {
+				tables[i] = e.(map[string]any)
+			}
+			return tables
+		}
+		return v
+	}
+	return v
+}
+
+// pruneZero drops the prune keys from v that prev does not have and whose
+// values are empty, so a file keeps only the fields its author wrote.
+func pruneZero(v, prev any, prune map[string]bool) any {
+	switch v := v.(type) {
+	case map[string]any:
+		p, _ := prev.(map[string]any)
+		for k, e := range v {
+			old, ok := p[k]
+			if prune[k] && !ok && emptyValue(e) {
+				delete(v, k)
+				continue
+			}
+			v[k] = pruneZero(e, old, childDefaults(k))
+		}
+		return v
+	case []map[string]any:
+		old := make(map[any]map[string]any)
+		switch p := prev.(type) {
+		case []map[string]any:
+			for _, m := range p {
+				old[m["id"]] = m
+			}
+		}
+		for i, m := range v {
+			var pm any
+			if o, ok := old[m["id"]]; ok {
+				pm = o
+			}
+			v[i] = pruneZero(m, pm, prune).(map[string]any)
+		}
+		return v
+	}
+	return v
+}
+
+func emptyValue(v any) bool {
+	switch v := v.(type) {
+	case string:
+		return v == ""
+	case bool:
+		return !v
+	case int64:
+		return v == 0
+	case float64:
+		return v == 0
+	case map[string]any:
+		return len(v) == 0
+	case []any:
+		return len(v) == 0
+	}
+	return false
+}
+
+// checkIncludedDoc rejects settings other than processes in an included
+// file; they only take effect in the main config.
+func checkIncludedDoc(data []byte) error {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	for key := range doc {
+		if key != "processes" {
+			return fmt.Errorf("only \"processes\" may be set in an included file, not %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// roundTrip encodes cfg over old in format and decodes the result again.
+func roundTrip(t *testing.T, format string, cfg *Config, old string) (*Config, []byte) {
+	t.Helper()
+	data, err := encodeConfig(format, cfg, []byte(old))
+	if err != nil {
+		t.Fatalf("encode %s: %v", format, err)
+	}
+	js, err := configToJSON(format, data)
+	if err != nil {
+		t.Fatalf("decode %s: %v\n%s", format, err, data)
+	}
+	got, err := parseConfig(js)
+	if err != nil {
+		t.Fatalf("parse %s: %v\n%s", format, err, data)
+	}
+	return got, data
+}
+
+func TestEncodeConfigRoundTrip(t *testing.T) {
+	zero := 0
+	want := &Config{
+		Include: []string{"conf.d/*.toml"},
+		Processes: []ProcessConfig{
+			{
+				ID:         "web",
+				Name:       "Web",
+				Executable: "/usr/bin/web",
+				Args:       []string{"--port", "0"},
+				WorkingDir: "/srv/web",
+				Env:        map[string]string{"EMPTY": "", "ZERO": "0"},
+				InheritEnv: &InheritEnv{All: false},
+			},
+			{
+				ID:         "worker",
+				Name:       "Worker",
+				Executable: "/usr/bin/worker",
+				WorkingDir: "/srv/worker",
+				InheritEnv: &InheritEnv{Names: []string{}},
+				Replicas:   &zero,
+				Instances:  []InstanceConfig{{Args: []string{"a"}}},
+			},
+		},
+	}
+
+	olds := map[string]string{
+		FormatYAML: "# main config\nprocesses:\n  - id: web # the web tier\n    name: Old\n",
+		FormatTOML: "[[processes]]\nid = \"web\"\nname = \"Old\"\n",
+	}
+	for _, format := range []string{FormatJSON, FormatYAML, FormatTOML} {
+		for _, old := range []string{"", olds[format]} {
+			got, data := roundTrip(t, format, want, old)
+			for i := range want.Processes {
+				w, g := want.Processes[i], got.Processes[i]
+				if w.Args == nil {
+					w.Args = g.Args // nil and empty are both written as no args
+				}
+				if !reflect.DeepEqual(w, g) {
+					t.Errorf("%s (old %q): process %s\n got %+v\nwant %+v\n%s", format, old, w.ID, g, w, data)
+				}
+			}
+			if !reflect.DeepEqual(got.Include, want.Include) {
+				t.Errorf("%s: include = %v, want %v", format, got.Include, want.Include)
+			}
+			if format == FormatYAML && old != "" && !strings.Contains(string(data), "# the web tier") {
+				t.Errorf("yaml comments were not kept:\n%s", data)
+			}
+		}
+	}
+}
+
+func TestEncodeConfigPrunesDefaults(t *testing.T) {
+	cfg := &Config{Processes: []ProcessConfig{{ID: "web", Name: "Web", Executable: "/usr/bin/web"}}}
+	for _, format := range []string{FormatYAML, FormatTOML} {
+		_, data := roundTrip(t, format, cfg, "")
+		for _, key := range []string{"auto_restart", "shutdown_delay", "service_name", "inherit_env", "replicas"} {
+			if strings.Contains(string(data), key) {
+				t.Errorf("%s: unset %s was written:\n%s", format, key, data)
+			}
+		}
+	}
+}
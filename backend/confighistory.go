@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	configHistoryPath = "config_history.jsonl"
+	configHistoryMax  = 100 // versions kept; older ones are dropped
+	configReasonMax   = 200
+)
+
+// ConfigVersion is one saved state of config.json. Config is left out of
+// history listings.
+type ConfigVersion struct {
+	Version     int             `json:"version"`
+	TimestampMS int64           `json:"timestamp_ms"`
+	User        string          `json:"user,omitempty"`
+	RemoteAddr  string          `json:"remote_addr,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	Config      json.RawMessage `json:"config,omitempty"`
+}
+
+// ConfigHistory keeps every version of config.json written by the manager
+// in a JSON-lines file, so a bad save can be rolled back.
+type ConfigHistory struct {
+	path     string
+	mu       sync.Mutex
+	versions []ConfigVersion // oldest first
+}
+
+func newConfigHistory(path string) *ConfigHistory {
+	h := &ConfigHistory{path: path}
+	if err := h.load(); err != nil {
+		log.Printf("[config] failed to load history from %s: %v", path, err)
+	}
+	return h
+}
+
+func (h *ConfigHistory) load() error {
+	file, err := os.Open(h.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var v ConfigVersion
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil || v.Version == 0 {
+			continue
+		}
+		h.versions = append(h.versions, v)
+	}
+	if len(h.versions) > configHistoryMax {
+		h.versions = h.versions[len(h.versions)-configHistoryMax:]
+	}
+	return scanner.Err()
+}
+
+// Record stores data as the newest version and returns its number.
+func (h *ConfigHistory) Record(a actor, reason string, data []byte) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	v := ConfigVersion{
+		Version:     1,
+		TimestampMS: time.Now().UnixMilli(),
+		RemoteAddr:  a.RemoteAddr,
+		Reason:      reason,
+		Config:      json.RawMessage(data),
+	}
+	if a.User != nil {
+		v.User = a.User.Name
+	}
+	if n := len(h.versions); n > 0 {
+		v.Version = h.versions[n-1].Version + 1
+	}
+	h.versions = append(h.versions, v)
+
+	if len(h.versions) > configHistoryMax {
+		h.versions = h.versions[len(h.versions)-configHistoryMax:]
+		h.rewrite()
+		return v.Version
+	}
+	line, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[config] failed to encode version %d: %v", v.Version, err)
+		return v.Version
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("[config] failed to open %s: %v", h.path, err)
+		return v.Version
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("[config] failed to write version %d: %v", v.Version, err)
+	}
+	return v.Version
+}
+
+// rewrite replaces the history file with the versions still kept. Caller
+// must hold h.mu.
+func (h *ConfigHistory) rewrite() {
+	var buf bytes.Buffer
+	for _, v := range h.versions {
+		line, err := json.Marshal(v)
+		if err != nil {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := writeFileAtomic(h.path, buf.Bytes(), 0600); err != nil {
+		log.Printf("[config] failed to trim history: %v", err)
+	}
+}
+
+// List returns the kept versions newest first, without their configs.
+func (h *ConfigHistory) List() []ConfigVersion {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	list := make([]ConfigVersion, 0, len(h.versions))
+	for i := len(h.versions) - 1; i >= 0; i-- {
+		v := h.versions[i]
+		v.Config = nil
+		list = append(list, v)
+	}
+	return list
+}
+
+// Get returns version n, if it is still kept.
+func (h *ConfigHistory) Get(n int) (ConfigVersion, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, v := range h.versions {
+		if v.Version == n {
+			return v, true
+		}
+	}
+	return ConfigVersion{}, false
+}
+
+// Latest returns the newest version, if there is one.
+func (h *ConfigHistory) Latest() (ConfigVersion, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.versions) == 0 {
+		return ConfigVersion{}, false
+	}
+	return h.versions[len(h.versions)-1], true
+}
+
+// Sync records data as a new version unless it matches the newest one. It
+// is called at startup so edits made while the manager was down, or before
+// history existed, are not lost by a later rollback.
+func (h *ConfigHistory) Sync(data []byte) {
+	compact := compactJSON(data)
+	if compact == nil {
+		return
+	}
+	if latest, ok := h.Latest(); ok && bytes.Equal(latest.Config, compact) {
+		return
+	}
+	h.Record(actor{}, "loaded from disk", compact)
+}
+
+// compactJSON strips insignificant whitespace, returning nil for invalid
+// JSON.
+func compactJSON(data []byte) []byte {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// ── Writing ──────────────────────────────────────────────────────────────────
+
+// writeConfigLocked saves cfg to its config files and records it in the
+// history. Every config write goes through here. It refuses to overwrite
+// edits made on disk that have not been reloaded yet. The caller must hold
+// pm.mu.
+func (pm *ProcessManager) writeConfigLocked(a actor, cfg *Config, reason string) error {
+	unlock, err := lockConfigFile(pm.configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := pm.checkConfigFileLocked(); err != nil {
+		return err
+	}
+	files, err := saveConfigFiles(cfg, pm.configFiles)
+	if err != nil {
+		return err
+	}
+	pm.configFiles = files
+	pm.fileSum = sumConfigFiles(files)
+	pm.updateConfigWatchLocked()
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	pm.history.Record(a, reason, data)
+	return nil
+}
+
+// configReason returns the ?reason= a config write was given, or fallback.
+func configReason(r *http.Request, fallback string) string {
+	reason := strings.TrimSpace(r.URL.Query().Get("reason"))
+	if reason == "" {
+		return fallback
+	}
+	if len(reason) > configReasonMax {
+		reason = reason[:configReasonMax]
+	}
+	return reason
+}
+
+// ── Handlers ─────────────────────────────────────────────────────────────────
+
+func (pm *ProcessManager) handleGetConfigHistory(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigRead, nil) {
+		return
+	}
+	writeJSON(w, http.StatusOK, pm.history.List())
+}
+
+// historyVersion looks up the version in the {v} path value, writing the
+// error response if there is none.
+func (pm *ProcessManager) historyVersion(w http.ResponseWriter, r *http.Request) (ConfigVersion, *Config, bool) {
+	n, err := strconv.Atoi(r.PathValue("v"))
+	if err != nil || n < 1 {
+		writeError(w, http.StatusBadRequest, "invalid version")
+		return ConfigVersion{}, nil, false
+	}
+	v, ok := pm.history.Get(n)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("version %d not found", n))
+		return ConfigVersion{}, nil, false
+	}
+	var cfg Config
+	if err := json.Unmarshal(v.Config, &cfg); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("version %d is unreadable: %v", n, err))
+		return ConfigVersion{}, nil, false
+	}
+	return v, &cfg, true
+}
+
+// handleConfigHistoryDiff lists what changed between a version and the live
+// config: before is the version's value, after the current one.
+func (pm *ProcessManager) handleConfigHistoryDiff(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigRead, nil) {
+		return
+	}
+	v, cfg, ok := pm.historyVersion(w, r)
+	if !ok {
+		return
+	}
+	u := requestUser(r)
+	pm.mu.RLock()
+	changes := diffConfig(redactAuth(cfg, u), redactAuth(pm.cfg, u))
+	pm.mu.RUnlock()
+
+	resp := map[string]any{"version": v.Version, "changes": changes}
+	if latest, ok := pm.history.Latest(); ok {
+		resp["current"] = latest.Version
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleConfigRollback makes an earlier version the live config. It is
+// applied like any other config write: validated, saved as a new version
+// and reconciled into the process table.
+func (pm *ProcessManager) handleConfigRollback(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigWrite, nil) {
+		return
+	}
+	v, cfg, ok := pm.historyVersion(w, r)
+	if !ok {
+		return
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	etag, err := pm.configETagLocked()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read config")
+		return
+	}
+	if !checkIfMatch(w, r, etag) {
+		return
+	}
+	if !authorizeAuthChange(w, r, pm.cfg.Auth, cfg.Auth) {
+		return
+	}
+	for _, id := range changedDefinitions(pm.cfg, cfg) {
+		if pm.scaling[id] {
+			writeError(w, http.StatusConflict, "changes to "+id+" are still being applied")
+			return
+		}
+	}
+
+	// Put each process back in the file it was in; versions record the
+	// main config's processes without a source
+	for i := range cfg.Processes {
+		if cfg.Processes[i].Source == "" {
+			cfg.Processes[i].Source = pm.configFiles[0].name
+		}
+	}
+
+	entry := AuditEntry{Action: AuditConfigWrite, Params: map[string]any{"rollback_to": v.Version}}
+	reason := configReason(r, fmt.Sprintf("rollback to version %d", v.Version))
+	restart, op, err := pm.applyConfigLocked(requestActor(r), cfg, reason, entry)
+	if err != nil {
+		pm.recordAudit(r, entry, err)
+		writeConfigError(w, err)
+		return
+	}
+	resp := map[string]any{"status": "config rolled back", "version": v.Version}
+	if latest, ok := pm.history.Latest(); ok {
+		resp["current"] = latest.Version
+	}
+	if len(restart) > 0 {
+		resp["restart_required"] = restart
+	}
+	if op != nil {
+		resp["operation"] = pm.ops.snapshot(op)
+	}
+	etag, _ = pm.configETagLocked()
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	configReloadDebounce = 500 * time.Millisecond // editors often write a file in several steps
+	configReloadRetry    = 2 * time.Second        // while a removal for a changed definition is running
+	configPollInterval   = 2 * time.Second        // when file events are unavailable
+)
+
+const configEventName = "config"
+
+const (
+	EventConfigReloaded     = "config_reloaded"
+	EventConfigReloadFailed = "config_reload_failed"
+)
+
+// fileActor is recorded as the author of changes picked up from disk.
+var fileActor = actor{User: localUser, RemoteAddr: "config file"}
+
+// errConfigConflict is returned by writeConfigLocked when the config files
+// no longer hold what the manager last loaded or wrote, so saving the live
+// config would throw away someone's edit.
+var errConfigConflict = errors.New("config was changed on disk")
+
+// errReloadBusy defers a reload until removals for a changed definition
+// have finished.
+var errReloadBusy = errors.New("changes are still being applied")
+
+// checkConfigFileLocked returns errConfigConflict if a config file was
+// edited since the config was last loaded or written. A missing main config
+// is not a conflict. The caller must hold pm.mu.
+func (pm *ProcessManager) checkConfigFileLocked() error {
+	sum, err := configStateSum(pm.configPath)
+	if err != nil {
+		return nil
+	}
+	if sum == pm.fileSum {
+		return nil
+	}
+	if pm.reloadErr != "" {
+		return fmt.Errorf("%w and could not be loaded (%s); fix or revert it, then retry", errConfigConflict, pm.reloadErr)
+	}
+	return fmt.Errorf("%w and is being reloaded; retry in a moment", errConfigConflict)
+}
+
+// configWatch is what the config watcher looks at: the main config and the
+// files its include patterns match.
+type configWatch struct {
+	watcher  *fsnotify.Watcher        // nil while polling
+	patterns atomic.Pointer[[]string] // absolute paths and globs
+}
+
+// watchConfig reloads the config whenever one of its files changes on disk.
+// File events are used where available, otherwise the files are polled.
+func (pm *ProcessManager) watchConfig() {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	if err := pm.watchConfigEvents(notify); err != nil {
+		log.Printf("[config] file events unavailable (%v); polling %s every %s", err, pm.configPath, configPollInterval)
+		go pm.pollConfig(notify)
+	}
+	pm.mu.Lock()
+	pm.updateConfigWatchLocked()
+	pm.mu.Unlock()
+
+	go func() {
+		var due <-chan time.Time
+		for {
+			select {
+			case <-changed:
+				due = time.After(configReloadDebounce)
+			case <-due:
+				due = nil
+				if err := pm.reloadConfig(); errors.Is(err, errReloadBusy) {
+					due = time.After(configReloadRetry)
+				}
+			}
+		}
+	}()
+}
+
+// updateConfigWatchLocked follows changes to the include patterns, watching
+// the directories they name. Directories that are themselves globs are not
+// watched. The caller must hold pm.mu.
+func (pm *ProcessManager) updateConfigWatchLocked() {
+	dir := filepath.Dir(pm.configPath)
+	patterns := []string{pm.configPath}
+	for _, p := range pm.cfg.Include {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(dir, p)
+		}
+		patterns = append(patterns, p)
+	}
+	for i, p := range patterns {
+		if abs, err := filepath.Abs(p); err == nil {
+			patterns[i] = abs
+		}
+	}
+	pm.watch.patterns.Store(&patterns)
+
+	if pm.watch.watcher == nil {
+		return
+	}
+	for _, p := range patterns {
+		d := filepath.Dir(p)
+		if strings.ContainsAny(d, "*?[") {
+			continue
+		}
+		if err := pm.watch.watcher.Add(d); err != nil && !os.IsNotExist(err) {
+			log.Printf("[config] failed to watch %s: %v", d, err)
+		}
+	}
+}
+
+// watchedConfigFile reports whether path is the main config or matches an
+// include pattern.
+func (pm *ProcessManager) watchedConfigFile(path string) bool {
+	patterns := pm.watch.patterns.Load()
+	if patterns == nil {
+		return false
+	}
+	for _, p := range *patterns {
+		if ok, _ := filepath.Match(p, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// watchConfigEvents watches the config files' directories rather than the
+// files themselves, so editors that save by replacing a file are followed.
+func (pm *ProcessManager) watchConfigEvents(notify func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(pm.configPath)
+	if err != nil {
+		watcher.Close()
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(abs)); err != nil {
+		watcher.Close()
+		return err
+	}
+	pm.watch.watcher = watcher
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if pm.watchedConfigFile(ev.Name) && !ev.Has(fsnotify.Chmod) {
+					notify()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("[config] watch error: %v", err)
+				notify() // events may have been lost
+			}
+		}
+	}()
+	return nil
+}
+
+// pollConfig hashes the config files on an interval; it is what
+// checkConfigFileLocked compares, so no change is missed.
+func (pm *ProcessManager) pollConfig(notify func()) {
+	last, _ := configStateSum(pm.configPath)
+	for range time.Tick(configPollInterval) {
+		sum, err := configStateSum(pm.configPath)
+		if err != nil {
+			continue
+		}
+		if sum != last {
+			notify()
+		}
+		last = sum
+	}
+}
+
+// reloadConfig applies the config files if they differ from what the
+// manager last loaded or wrote. Files that do not parse or validate are left
+// alone and the live config is kept; config writes are refused until they
+// are fixed or reverted, so the edit is not overwritten.
+func (pm *ProcessManager) reloadConfig() error {
+	cfg, files, _, loadErr := loadConfigFiles(pm.configPath, false)
+	var sum [32]byte
+	if loadErr == nil {
+		sum = sumConfigFiles(files)
+	} else {
+		var err error
+		if sum, err = configStateSum(pm.configPath); err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("[config] failed to read %s: %v", pm.configPath, err)
+			}
+			return err
+		}
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if sum == pm.fileSum {
+		// Our own write, or an edit that was reverted
+		pm.reloadErr = ""
+		return nil
+	}
+	if pm.reloadErr != "" && sum == pm.rejectedSum {
+		return nil
+	}
+
+	err := loadErr
+	if err == nil {
+		err = validateConfig(cfg)
+	}
+	if err == nil {
+		err = pm.secrets.checkSecretRefs(cfg)
+	}
+	if err != nil {
+		pm.rejectedSum = sum
+		pm.reloadErr = pm.secrets.Redact(err.Error())
+		log.Printf("[config] not reloading %s: %s", pm.configPath, pm.reloadErr)
+		pm.events.RecordReason("", configEventName, EventConfigReloadFailed, pm.reloadErr)
+		pm.recordAuditAs(fileActor, AuditEntry{Action: AuditConfigReload}, err)
+		return err
+	}
+
+	ids := changedDefinitions(pm.cfg, cfg)
+	for _, id := range ids {
+		if pm.scaling[id] {
+			return errReloadBusy
+		}
+	}
+
+	pm.fileSum = sum
+	pm.configFiles = files
+	pm.reloadErr = ""
+	if data, err := json.Marshal(cfg); err == nil {
+		pm.history.Record(fileActor, "edited on disk", data)
+	}
+	restart, _, err := pm.reconcileConfigLocked(fileActor, cfg, AuditEntry{Action: AuditConfigReload})
+	if err != nil {
+		log.Printf("[config] reloaded %s, but removing processes failed: %v", pm.configPath, err)
+	}
+	pm.updateConfigWatchLocked()
+
+	summary := "no process changes"
+	if len(ids) > 0 {
+		summary = "changed: " + strings.Join(ids, ", ")
+	}
+	if len(restart) > 0 {
+		summary += "; restart required: " + strings.Join(restart, ", ")
+	}
+	log.Printf("[config] reloaded %s (%s)", pm.configPath, summary)
+	pm.events.RecordReason("", configEventName, EventConfigReloaded, summary)
+	return nil
+}
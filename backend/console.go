@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	consoleMaxInput     = 4096            // bytes per command
+	consoleWriteTimeout = 2 * time.Second // a process that stops reading its stdin fills the pipe
+)
+
+var (
+	errNoConsole  = errors.New("services have no console")
+	errNotRunning = errors.New("process is not running")
+)
+
+// writeConsole sends one line of input to a running process's stdin,
+// adding the newline if it is missing.
+func (mp *ManagedProcess) writeConsole(input string) error {
+	if mp.Config().IsService {
+		return errNoConsole
+	}
+	mp.mu.Lock()
+	stdin := mp.stdin
+	mp.mu.Unlock()
+	if stdin == nil {
+		return errNotRunning
+	}
+
+	if !strings.HasSuffix(input, "\n") {
+		input += "\n"
+	}
+
+	// One write at a time, so a process that stops reading holds up at
+	// most one blocked write
+	timer := time.NewTimer(consoleWriteTimeout)
+	defer timer.Stop()
+	select {
+	case mp.consoleWrite <- struct{}{}:
+	case <-timer.C:
+		return fmt.Errorf("write to stdin: %w", os.ErrDeadlineExceeded)
+	}
+
+	// The pipe is closed when the process exits, so a write racing with
+	// the exit fails instead of blocking
+	if err := stdin.SetWriteDeadline(time.Now().Add(consoleWriteTimeout)); err == nil {
+		defer func() { <-mp.consoleWrite }()
+		if _, err := stdin.WriteString(input); err != nil {
+			return fmt.Errorf("write to stdin: %w", err)
+		}
+		return nil
+	}
+
+	// Pipes without deadlines, such as Windows anonymous pipes: write in
+	// the background and give up waiting. The write stays pending, and
+	// holds the slot, until the process reads or exits.
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdin.WriteString(input)
+		<-mp.consoleWrite
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("write to stdin: %w", err)
+		}
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("write to stdin: %w", os.ErrDeadlineExceeded)
+	}
+}
+
+// sendConsole writes console input and audits it. The REST and WebSocket
+// handlers share it.
+func (pm *ProcessManager) sendConsole(a actor, mp *ManagedProcess, input string) error {
+	err := mp.writeConsole(input)
+	pm.recordAuditAs(a, AuditEntry{
+		Action: AuditConsole,
+		Target: mp.Config().ID,
+		Params: map[string]any{"input": pm.secrets.Redact(strings.TrimRight(input, "\r\n"))},
+	}, err)
+	return err
+}
+
+// consoleStatus maps a console error to an HTTP status.
+func consoleStatus(err error) int {
+	if errors.Is(err, errNoConsole) || errors.Is(err, errNotRunning) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+func validConsoleInput(input string) error {
+	switch {
+	case strings.TrimSpace(input) == "":
+		return errors.New("input is empty")
+	case len(input) > consoleMaxInput:
+		return fmt.Errorf("input exceeds %d bytes", consoleMaxInput)
+	case strings.ContainsAny(strings.TrimRight(input, "\r\n"), "\r\n"):
+		return errors.New("input must be a single line")
+	}
+	return nil
+}
+
+// handleConsoleInput sends a line to a process's stdin.
+func (pm *ProcessManager) handleConsoleInput(w http.ResponseWriter, r *http.Request) {
+	pm.mu.RLock()
+	mp, ok := pm.processes[r.PathValue("id")]
+	pm.mu.RUnlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "process not found")
+		return
+	}
+	if !authorize(w, r, PermConsole, mp.Config()) {
+		return
+	}
+
+	var body struct {
+		Input string `json:"input"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validConsoleInput(body.Input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := pm.sendConsole(requestActor(r), mp, body.Input); err != nil {
+		writeError(w, consoleStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "input sent"})
+}
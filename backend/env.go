@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+const redactedValue = "********"
+
+// Environment variable sources, as reported by the env API
+const (
+	EnvSourceInherited = "inherited"
+	EnvSourceFile      = "env_file"
+	EnvSourceConfig    = "env"
+)
+
+// sensitiveEnvMarkers flag variables whose values are never shown by the API.
+var sensitiveEnvMarkers = []string{
+	"PASSWORD", "PASSWD", "SECRET", "TOKEN", "API_KEY", "APIKEY",
+	"PRIVATE_KEY", "CREDENTIAL", "DSN",
+}
+
+// InheritEnv controls which of the manager's own variables a process sees:
+// true (the default) passes everything, false nothing, and a list of names
+// only those.
+type InheritEnv struct {
+	All   bool
+	Names []string
+}
+
+func (ie *InheritEnv) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &ie.All); err == nil {
+		ie.Names = nil
+		return nil
+	}
+	ie.All = false
+	if err := json.Unmarshal(data, &ie.Names); err != nil {
+		return fmt.Errorf("inherit_env must be a bool or a list of variable names")
+	}
+	if ie.Names == nil {
+		ie.Names = []string{}
+	}
+	return nil
+}
+
+func (ie InheritEnv) MarshalJSON() ([]byte, error) {
+	if ie.Names != nil {
+		return json.Marshal(ie.Names)
+	}
+	return json.Marshal(ie.All)
+}
+
+func (ie *InheritEnv) allows(name string) bool {
+	if ie == nil || (ie.All && ie.Names == nil) {
+		return true
+	}
+	for _, n := range ie.Names {
+		if envKey(n) == envKey(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// EnvVar is one entry of a process's effective environment.
+type EnvVar struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Source   string `json:"source"`
+	Redacted bool   `json:"redacted,omitempty"`
+}
+
+// processEnv is an environment under construction. Later sources override
+// earlier ones: inherited variables, then env_files in order, then env.
+type processEnv struct {
+	vars    map[string]*EnvVar
+	secrets *SecretStore
+	err     error // first unknown ${secret:name} reference
+}
+
+// envKey normalises a variable name; Windows names are case-insensitive.
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
+
+func validEnvName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "=\x00")
+}
+
+func (e *processEnv) get(name string) (string, bool) {
+	if v, ok := e.vars[envKey(name)]; ok {
+		return v.Value, true
+	}
+	return "", false
+}
+
+func (e *processEnv) set(name, value, source string) {
+	e.vars[envKey(name)] = &EnvVar{Name: name, Value: value, Source: source}
+}
+
+// lookupRef resolves a reference that is not another variable: "$$" or a
+// ${secret:name}. ok is false for plain variable names.
+func (e *processEnv) lookupRef(ref string) (string, bool) {
+	if ref == "$" {
+		return "$", true
+	}
+	name, isSecret := strings.CutPrefix(ref, secretRefPrefix)
+	if !isSecret {
+		return "", false
+	}
+	if e.secrets == nil {
+		return "", true // validation only checks the shape of references
+	}
+	v, ok := e.secrets.Lookup(name)
+	if !ok && e.err == nil {
+		e.err = fmt.Errorf("unknown secret: %s", name)
+	}
+	return v, true
+}
+
+// expand substitutes $VAR, ${VAR} and ${secret:name} from the variables
+// defined so far. "$$" yields a literal dollar sign.
+func (e *processEnv) expand(s string) string {
+	return os.Expand(s, func(ref string) string {
+		if v, ok := e.lookupRef(ref); ok {
+			return v
+		}
+		v, _ := e.get(ref)
+		return v
+	})
+}
+
+// applyMap sets the config's env map. Values may reference each other in any
+// order; a variable referencing itself sees its previous value, so
+// "PATH": "/opt/bin:$PATH" prepends as expected.
+func (e *processEnv) applyMap(vars map[string]string) error {
+	const (
+		resolving = 1
+		resolved  = 2
+	)
+	state := make(map[string]int, len(vars))
+	var cycle error
+
+	var resolve func(name string)
+	resolve = func(name string) {
+		switch state[name] {
+		case resolved:
+			return
+		case resolving:
+			if cycle == nil {
+				cycle = fmt.Errorf("env: circular reference involving %s", name)
+			}
+			return
+		}
+		state[name] = resolving
+		value := os.Expand(vars[name], func(ref string) string {
+			if v, ok := e.lookupRef(ref); ok {
+				return v
+			}
+			if _, ok := vars[ref]; ok && ref != name {
+				resolve(ref)
+			}
+			v, _ := e.get(ref)
+			return v
+		})
+		e.set(name, value, EnvSourceConfig)
+		state[name] = resolved
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resolve(name)
+	}
+	return cycle
+}
+
+// environ returns the environment in the form expected by exec.Cmd.Env.
+func (e *processEnv) environ() []string {
+	out := make([]string, 0, len(e.vars))
+	for _, v := range e.list() {
+		out = append(out, v.Name+"="+v.Value)
+	}
+	return out
+}
+
+func (e *processEnv) list() []EnvVar {
+	out := make([]EnvVar, 0, len(e.vars))
+	for _, v := range e.vars {
+		out = append(out, *v)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// buildProcessEnv computes the environment a process is started with.
+func buildProcessEnv(pc *ProcessConfig, secrets *SecretStore) (*processEnv, error) {
+	e := &processEnv{vars: make(map[string]*EnvVar), secrets: secrets}
+
+	for _, kv := range os.Environ() {
+		// Windows has hidden per-drive entries such as "=C:=C:\"
+		i := strings.Index(kv[min(1, len(kv)):], "=") + 1
+		if i <= 0 {
+			continue
+		}
+		if name := kv[:i]; pc.InheritEnv.allows(name) {
+			e.set(name, kv[i+1:], EnvSourceInherited)
+		}
+	}
+
+	for _, path := range pc.EnvFiles {
+		if !filepath.IsAbs(path) && pc.WorkingDir != "" {
+			path = filepath.Join(pc.WorkingDir, path)
+		}
+		if pc.Chroot != "" {
+			path = filepath.Join(pc.Chroot, path)
+		}
+		entries, err := parseEnvFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, ent := range entries {
+			value := ent.value
+			if ent.expand {
+				value = e.expand(value)
+			}
+			e.set(ent.name, value, EnvSourceFile+":"+path)
+		}
+	}
+
+	if err := e.applyMap(pc.Env); err != nil {
+		return nil, err
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e, nil
+}
+
+type envFileEntry struct {
+	name   string
+	value  string
+	expand bool
+}
+
+// parseEnvFile reads a dotenv file: NAME=value lines with optional "export "
+// prefix, # comments, and single- (literal) or double-quoted values.
+func parseEnvFile(path string) ([]envFileEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("env file: %w", err)
+	}
+	defer f.Close()
+
+	var entries []envFileEntry
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, raw, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validEnvName(name) {
+			return nil, fmt.Errorf("env file %s:%d: expected NAME=value", path, lineNo)
+		}
+		value, expand, err := parseEnvValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("env file %s:%d: %w", path, lineNo, err)
+		}
+		entries = append(entries, envFileEntry{name: name, value: value, expand: expand})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("env file %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+func parseEnvValue(raw string) (value string, expand bool, err error) {
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", false, fmt.Errorf("unterminated single quote")
+		}
+		return raw[1 : end+1], false, nil
+
+	case strings.HasPrefix(raw, `"`):
+		var b strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			if c == '"' {
+				return b.String(), true, nil
+			}
+			if c == '\\' && i+1 < len(raw) {
+				i++
+				switch raw[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case '$':
+					b.WriteString("$$") // kept literal through expansion
+				default:
+					b.WriteByte(raw[i])
+				}
+				continue
+			}
+			b.WriteByte(c)
+		}
+		return "", false, fmt.Errorf("unterminated double quote")
+
+	default:
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = strings.TrimSpace(raw[:i])
+		}
+		return raw, true, nil
+	}
+}
+
+// redactEnv hides values of variables that look like credentials or hold
+// stored secrets, and passwords embedded in URLs such as database
+// connection strings.
+func redactEnv(v EnvVar, secrets *SecretStore) EnvVar {
+	if masked := secrets.Redact(v.Value); masked != v.Value {
+		v.Value, v.Redacted = masked, true
+		return v
+	}
+	upper := strings.ToUpper(v.Name)
+	for _, marker := range sensitiveEnvMarkers {
+		if strings.Contains(upper, marker) {
+			v.Value, v.Redacted = redactedValue, true
+			return v
+		}
+	}
+	if u, err := url.Parse(v.Value); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok && password != "" {
+			v.Value = strings.Replace(v.Value, ":"+password+"@", ":"+redactedValue+"@", 1)
+			v.Redacted = true
+		}
+	}
+	return v
+}
+
+func validateProcessEnv(pc *ProcessConfig) error {
+	if pc.IsService && (len(pc.Env) > 0 || len(pc.EnvFiles) > 0 || pc.InheritEnv != nil) {
+		return fmt.Errorf("%s: env settings are not supported for services", pc.ID)
+	}
+	for name := range pc.Env {
+		if !validEnvName(name) {
+			return fmt.Errorf("%s: invalid env variable name %q", pc.ID, name)
+		}
+	}
+	if pc.InheritEnv != nil {
+		for _, name := range pc.InheritEnv.Names {
+			if !validEnvName(name) {
+				return fmt.Errorf("%s: invalid inherit_env variable name %q", pc.ID, name)
+			}
+		}
+	}
+	for _, path := range pc.EnvFiles {
+		if containsDangerousChars(path) {
+			return fmt.Errorf("invalid env file path: %s", path)
+		}
+	}
+	// Catch circular references now rather than at the next start
+	if err := (&processEnv{vars: make(map[string]*EnvVar)}).applyMap(pc.Env); err != nil {
+		return fmt.Errorf("%s: %w", pc.ID, err)
+	}
+	return nil
+}
+
+func (pm *ProcessManager) handleGetEnv(w http.ResponseWriter, r *http.Request) {
+	pm.mu.RLock()
+	mp, ok := pm.processes[r.PathValue("id")]
+	pm.mu.RUnlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "process not found")
+		return
+	}
+	if !authorize(w, r, PermConfigRead, mp.Config()) {
+		return
+	}
+
+	mp.mu.Lock()
+	pc := *mp.Config()
+	mp.mu.Unlock()
+
+	env, err := buildProcessEnv(&pc, pm.secrets)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	vars := env.list()
+	for i := range vars {
+		vars[i] = redactEnv(vars[i], pm.secrets)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"variables": vars})
+}
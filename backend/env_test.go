@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseEnvValue(t *testing.T) {
+	tests := []struct {
+		raw        string
+		want       string
+		wantExpand bool
+		wantErr    string
+	}{
+		{raw: "", want: "", wantExpand: true},
+		{raw: "plain", want: "plain", wantExpand: true},
+		{raw: "value # comment", want: "value", wantExpand: true},
+		{raw: "a#b", want: "a#b", wantExpand: true},
+		{raw: "$HOME/bin", want: "$HOME/bin", wantExpand: true},
+		{raw: "'single $HOME \\n'", want: "single $HOME \\n", wantExpand: false},
+		{raw: "'a' trailing", want: "a", wantExpand: false},
+		{raw: "''", want: "", wantExpand: false},
+		{raw: `"double $HOME"`, want: "double $HOME", wantExpand: true},
+		{raw: `"line\nbreak\ttab"`, want: "line\nbreak\ttab", wantExpand: true},
+		{raw: `"say \"hi\" \\ ok"`, want: `say "hi" \ ok`, wantExpand: true},
+		{raw: `"cost \$5"`, want: "cost $$5", wantExpand: true},
+		{raw: `"a # not a comment"`, want: "a # not a comment", wantExpand: true},
+		{raw: "'open", wantErr: "unterminated single quote"},
+		{raw: `"open`, wantErr: "unterminated double quote"},
+		{raw: `"ends in backslash\"`, wantErr: "unterminated double quote"},
+	}
+	for _, tt := range tests {
+		got, expand, err := parseEnvValue(tt.raw)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseEnvValue(%q) error = %v, want %q", tt.raw, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseEnvValue(%q): %v", tt.raw, err)
+			continue
+		}
+		if got != tt.want || expand != tt.wantExpand {
+			t.Errorf("parseEnvValue(%q) = %q, %v; want %q, %v", tt.raw, got, expand, tt.want, tt.wantExpand)
+		}
+	}
+}
+
+func TestApplyMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    map[string]string // set before the map is applied
+		vars    map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "plain values",
+			vars: map[string]string{"A": "1", "B": "two"},
+			want: map[string]string{"A": "1", "B": "two"},
+		},
+		{
+			name: "references in any order",
+			vars: map[string]string{"A": "${B}-a", "B": "$C-b", "C": "c"},
+			want: map[string]string{"A": "c-b-a", "B": "c-b", "C": "c"},
+		},
+		{
+			name: "self reference sees the previous value",
+			base: map[string]string{"PATH": "/usr/bin"},
+			vars: map[string]string{"PATH": "/opt/bin:$PATH"},
+			want: map[string]string{"PATH": "/opt/bin:/usr/bin"},
+		},
+		{
+			name: "self reference without a previous value",
+			vars: map[string]string{"X": "a${X}b"},
+			want: map[string]string{"X": "ab"},
+		},
+		{
+			name: "reference to an earlier source",
+			base: map[string]string{"HOME": "/home/app"},
+			vars: map[string]string{"DATA": "$HOME/data"},
+			want: map[string]string{"DATA": "/home/app/data", "HOME": "/home/app"},
+		},
+		{
+			name: "undefined reference is empty",
+			vars: map[string]string{"A": "[$MISSING]"},
+			want: map[string]string{"A": "[]"},
+		},
+		{
+			name: "double dollar is a literal dollar",
+			vars: map[string]string{"PRICE": "$$5", "B": "$PRICE"},
+			want: map[string]string{"PRICE": "$5", "B": "$5"},
+		},
+		{
+			name:    "two-variable cycle",
+			vars:    map[string]string{"A": "$B", "B": "$A"},
+			wantErr: "circular reference",
+		},
+		{
+			name:    "longer cycle",
+			vars:    map[string]string{"A": "$B", "B": "${C}", "C": "x$A", "D": "ok"},
+			wantErr: "circular reference",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &processEnv{vars: make(map[string]*EnvVar)}
+			for k, v := range tt.base {
+				e.set(k, v, EnvSourceInherited)
+			}
+			err := e.applyMap(tt.vars)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, v := range e.list() {
+				got[v.Name] = v.Value
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			for k, want := range tt.want {
+				if got[k] != want {
+					t.Errorf("%s = %q, want %q", k, got[k], want)
+				}
+			}
+		})
+	}
+}
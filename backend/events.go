@@ -6,9 +6,12 @@ import (
 )
 
 const (
-	EventStarted = "started"
-	EventStopped = "stopped"
-	EventCrashed = "crashed"
+	EventStarted   = "started"
+	EventStopped   = "stopped"
+	EventCrashed   = "crashed"
+	EventRestarted = "restarted"
+	EventAdded     = "added"   // a replica was created by scaling up
+	EventRemoved   = "removed" // a replica was removed by scaling down
 )
 
 type Event struct {
@@ -16,6 +19,8 @@ type Event struct {
 	ProcessID   string `json:"process_id"`
 	ProcessName string `json:"process_name"`
 	Type        string `json:"type"`
+	DurationMS  int64  `json:"duration_ms,omitempty"` // how long the action took, when timed
+	Reason      string `json:"reason,omitempty"`      // why a process crashed, e.g. "oom_killed", or a restart failed
 }
 
 type EventStore struct {
@@ -23,13 +28,26 @@ type EventStore struct {
 	head   int
 	count  int
 	mu     sync.Mutex
+	notify func(Event) // called with each new event, outside the lock
 }
 
 // Record adds a new event to the ring buffer
 func (es *EventStore) Record(id, name, eventType string) {
-	es.mu.Lock()
-	defer es.mu.Unlock()
+	es.add(Event{ProcessID: id, ProcessName: name, Type: eventType})
+}
+
+// RecordTimed adds an event along with how long the action took
+func (es *EventStore) RecordTimed(id, name, eventType string, d time.Duration) {
+	es.add(Event{ProcessID: id, ProcessName: name, Type: eventType, DurationMS: d.Milliseconds()})
+}
 
+// RecordReason adds an event explaining why it happened
+func (es *EventStore) RecordReason(id, name, eventType, reason string) {
+	es.add(Event{ProcessID: id, ProcessName: name, Type: eventType, Reason: reason})
+}
+
+func (es *EventStore) add(ev Event) {
+	es.mu.Lock()
 	if es.count < len(es.events) {
 		es.count++
 	} else {
@@ -37,11 +55,12 @@ func (es *EventStore) Record(id, name, eventType string) {
 	}
 
 	idx := (es.head + es.count - 1) % len(es.events)
-	es.events[idx] = Event{
-		TimestampMS: time.Now().UnixMilli(),
-		ProcessID:   id,
-		ProcessName: name,
-		Type:        eventType,
+	ev.TimestampMS = time.Now().UnixMilli()
+	es.events[idx] = ev
+	es.mu.Unlock()
+
+	if es.notify != nil {
+		es.notify(ev)
 	}
 }
 
@@ -2,11 +2,13 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 
@@ -52,71 +54,49 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 }
 
 func (pm *ProcessManager) handleGetProcesses(w http.ResponseWriter, r *http.Request) {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
-
-	statuses := make([]ProcessStatus, 0, len(pm.order))
-	for _, id := range pm.order {
-		mp := pm.processes[id]
-		mp.mu.Lock()
-		statuses = append(statuses, pm.getStatus(mp))
-		mp.mu.Unlock()
+	if r.URL.Query().Get("view") == "config" {
+		pm.handleListDefinitions(w, r)
+		return
 	}
-
-	writeJSON(w, http.StatusOK, statuses)
+	writeJSON(w, http.StatusOK, requestUser(r).visibleStatuses(pm.statuses()))
 }
 
-func (pm *ProcessManager) handleStart(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
+// lookupControllable resolves the {id} path value to a process the caller
+// may control, writing the error response otherwise.
+func (pm *ProcessManager) lookupControllable(w http.ResponseWriter, r *http.Request) (*ManagedProcess, bool) {
 	pm.mu.RLock()
-	mp, ok := pm.processes[id]
+	mp, ok := pm.processes[r.PathValue("id")]
 	pm.mu.RUnlock()
 
 	if !ok {
 		writeError(w, http.StatusNotFound, "process not found")
-		return
+		return nil, false
 	}
-
-	if err := pm.startProcess(mp, true); err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+	if !authorize(w, r, PermControl, mp.Config()) {
+		return nil, false
 	}
-
-	writeJSON(w, http.StatusOK, map[string]string{"status": "started"})
+	return mp, true
 }
 
-func (pm *ProcessManager) handleStop(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("id")
-	pm.mu.RLock()
-	mp, ok := pm.processes[id]
-	pm.mu.RUnlock()
-
-	if !ok {
-		writeError(w, http.StatusNotFound, "process not found")
-		return
+func (pm *ProcessManager) handleStart(w http.ResponseWriter, r *http.Request) {
+	if mp, ok := pm.lookupControllable(w, r); ok {
+		pm.submitAndRespond(w, r, OpStart, []*ManagedProcess{mp})
 	}
+}
 
-	if err := pm.stopProcess(mp); err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
-		return
+// handleStop stops a process and disables its auto-restart so it stays down.
+func (pm *ProcessManager) handleStop(w http.ResponseWriter, r *http.Request) {
+	if mp, ok := pm.lookupControllable(w, r); ok {
+		pm.submitAndRespond(w, r, OpStop, []*ManagedProcess{mp})
 	}
+}
 
-	// Disable auto-restart on manual stop and persist to config
-	mp.mu.Lock()
-	mp.Config.AutoRestart = false
-	mp.mu.Unlock()
-
-	pm.mu.Lock()
-	for i, pc := range pm.cfg.Processes {
-		if pc.ID == id {
-			pm.cfg.Processes[i].AutoRestart = false
-			break
-		}
+// handleRestart stops and starts a process as one operation, keeping its
+// auto-restart setting.
+func (pm *ProcessManager) handleRestart(w http.ResponseWriter, r *http.Request) {
+	if mp, ok := pm.lookupControllable(w, r); ok {
+		pm.submitAndRespond(w, r, OpRestart, []*ManagedProcess{mp})
 	}
-	pm.cfg.saveConfig(pm.configPath)
-	pm.mu.Unlock()
-
-	writeJSON(w, http.StatusOK, map[string]string{"status": "stopped"})
 }
 
 func (pm *ProcessManager) handleToggleAutoRestart(w http.ResponseWriter, r *http.Request) {
@@ -130,6 +110,10 @@ func (pm *ProcessManager) handleToggleAutoRestart(w http.ResponseWriter, r *http
 		return
 	}
 
+	if !authorize(w, r, PermControl, mp.Config()) {
+		return
+	}
+
 	var body struct {
 		AutoRestart bool `json:"auto_restart"`
 	}
@@ -138,22 +122,24 @@ func (pm *ProcessManager) handleToggleAutoRestart(w http.ResponseWriter, r *http
 		return
 	}
 
-	mp.mu.Lock()
-	mp.Config.AutoRestart = body.AutoRestart
-	mp.mu.Unlock()
+	pm.applyAutoRestart(requestActor(r), mp, body.AutoRestart)
+	writeJSON(w, http.StatusOK, map[string]bool{"auto_restart": body.AutoRestart})
+}
 
-	// Persist to config.json
-	pm.mu.Lock()
-	for i, pc := range pm.cfg.Processes {
-		if pc.ID == id {
-			pm.cfg.Processes[i].AutoRestart = body.AutoRestart
-			break
-		}
-	}
-	pm.cfg.saveConfig(pm.configPath)
-	pm.mu.Unlock()
+// applyAutoRestart sets a process's auto-restart flag, persists it and
+// audits the change. The REST and WebSocket handlers share it.
+func (pm *ProcessManager) applyAutoRestart(a actor, mp *ManagedProcess, enabled bool) {
+	before, changed := pm.setAutoRestart(a, mp, enabled)
 
-	writeJSON(w, http.StatusOK, map[string]bool{"auto_restart": body.AutoRestart})
+	entry := AuditEntry{
+		Action: AuditAutoRestart,
+		Target: mp.Config().ID,
+		Params: map[string]any{"auto_restart": enabled},
+	}
+	if changed {
+		entry.Changes = []ConfigChange{autoRestartChange(mp.Config().ID, before, enabled)}
+	}
+	pm.recordAuditAs(a, entry, nil)
 }
 
 func (pm *ProcessManager) handleGetLogs(w http.ResponseWriter, r *http.Request) {
@@ -167,8 +153,12 @@ func (pm *ProcessManager) handleGetLogs(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if !authorize(w, r, PermLogsRead, mp.Config()) {
+		return
+	}
+
 	// Windows Services don't have a managed log file
-	if mp.Config.IsService {
+	if mp.Config().IsService {
 		writeJSON(w, http.StatusOK, map[string][]string{"lines": {}})
 		return
 	}
@@ -187,6 +177,9 @@ func (pm *ProcessManager) handleGetLogs(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	for i, line := range lines {
+		lines[i] = pm.secrets.Redact(line)
+	}
 	writeJSON(w, http.StatusOK, map[string][]string{"lines": lines})
 }
 
@@ -264,7 +257,14 @@ func containsDangerousChars(s string) bool {
 
 // validateConfig checks that all paths and args in the config are safe
 func validateConfig(cfg *Config) error {
-	for _, pc := range cfg.Processes {
+	if err := validateReplicas(cfg); err != nil {
+		return err
+	}
+	for _, pc := range expandProcesses(cfg.Processes) {
+		// IDs become file and cgroup names, so none may escape their directory
+		if !validProcessID(pc.ID) {
+			return fmt.Errorf("invalid process ID %q: %s", pc.ID, processIDRule)
+		}
 		if containsDangerousChars(pc.Executable) {
 			return fmt.Errorf("invalid executable path: %s", pc.Executable)
 		}
@@ -279,6 +279,15 @@ func validateConfig(cfg *Config) error {
 				return fmt.Errorf("invalid argument: %s", arg)
 			}
 		}
+		if err := validateProcessEnv(&pc); err != nil {
+			return err
+		}
+		if err := pc.Limits.validate(pc.ID); err != nil {
+			return err
+		}
+		if err := validateIdentity(&pc); err != nil {
+			return err
+		}
 		// Validate log rotation settings
 		if pc.LogMaxSizeMB < 0 {
 			return fmt.Errorf("log_max_size_mb must be >= 0")
@@ -290,82 +299,46 @@ func validateConfig(cfg *Config) error {
 			return fmt.Errorf("log_max_age_days must be >= 0")
 		}
 	}
-	return nil
+	if err := cfg.Server.validate(); err != nil {
+		return err
+	}
+	if err := cfg.Host.validate(); err != nil {
+		return err
+	}
+	return cfg.Auth.validate()
 }
 
-func (pm *ProcessManager) handleStartAll(w http.ResponseWriter, r *http.Request) {
+// controllableProcesses returns the processes the caller may control, in
+// config order.
+func (pm *ProcessManager) controllableProcesses(r *http.Request) []*ManagedProcess {
+	user := requestUser(r)
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
-	errors := make(map[string]string)
+	targets := make([]*ManagedProcess, 0, len(pm.order))
 	for _, id := range pm.order {
-		mp := pm.processes[id]
-		if err := pm.startProcess(mp, true); err != nil {
-			errors[id] = err.Error()
+		if mp := pm.processes[id]; user.canProcess(PermControl, mp.Config()) {
+			targets = append(targets, mp)
 		}
 	}
+	return targets
+}
 
-	if len(errors) > 0 {
-		writeJSON(w, http.StatusMultiStatus, map[string]any{
-			"status": "partial",
-			"errors": errors,
-		})
-	} else {
-		writeJSON(w, http.StatusOK, map[string]string{"status": "all started"})
-	}
+func (pm *ProcessManager) handleStartAll(w http.ResponseWriter, r *http.Request) {
+	pm.submitAndRespond(w, r, OpStartAll, pm.controllableProcesses(r))
 }
 
 func (pm *ProcessManager) handleStopAll(w http.ResponseWriter, r *http.Request) {
-	pm.mu.RLock()
-	order := make([]string, len(pm.order))
-	copy(order, pm.order)
-	pm.mu.RUnlock()
-
-	errors := make(map[string]string)
-
+	targets := pm.controllableProcesses(r)
 	// Stop in reverse order
-	for i := len(order) - 1; i >= 0; i-- {
-		id := order[i]
-		pm.mu.RLock()
-		mp, ok := pm.processes[id]
-		pm.mu.RUnlock()
-
-		if !ok {
-			continue
-		}
-
-		if err := pm.stopProcess(mp); err != nil {
-			errors[id] = err.Error()
-		}
-
-		// Disable auto-restart on all processes and persist
-		mp.mu.Lock()
-		mp.Config.AutoRestart = false
-		mp.mu.Unlock()
-
-		pm.mu.Lock()
-		for j, pc := range pm.cfg.Processes {
-			if pc.ID == id {
-				pm.cfg.Processes[j].AutoRestart = false
-				break
-			}
-		}
-		pm.mu.Unlock()
-	}
-
-	// Persist to config once
-	pm.mu.Lock()
-	pm.cfg.saveConfig(pm.configPath)
-	pm.mu.Unlock()
+	slices.Reverse(targets)
+	pm.submitAndRespond(w, r, OpStopAll, targets)
+}
 
-	if len(errors) > 0 {
-		writeJSON(w, http.StatusMultiStatus, map[string]any{
-			"status": "partial",
-			"errors": errors,
-		})
-	} else {
-		writeJSON(w, http.StatusOK, map[string]string{"status": "all stopped"})
-	}
+// handleRestartAll stops every permitted process in reverse order, then
+// starts them again in config order, without touching auto-restart.
+func (pm *ProcessManager) handleRestartAll(w http.ResponseWriter, r *http.Request) {
+	pm.submitAndRespond(w, r, OpRestartAll, pm.controllableProcesses(r))
 }
 
 func (pm *ProcessManager) handleGetMetrics(w http.ResponseWriter, r *http.Request) {
@@ -379,6 +352,16 @@ func (pm *ProcessManager) handleGetMetrics(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if !authorize(w, r, PermView, mp.Config()) {
+		return
+	}
+
+	fields, err := parseMetricFields(r.URL.Query().Get("fields"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	minutes := 5
 	if s := r.URL.Query().Get("minutes"); s != "" {
 		if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 60 {
@@ -390,25 +373,69 @@ func (pm *ProcessManager) handleGetMetrics(w http.ResponseWriter, r *http.Reques
 	points := mp.metrics.Last(minutes * 60)
 	mp.mu.Unlock()
 
+	if fields != nil {
+		writeJSON(w, http.StatusOK, map[string]any{"points": selectMetricFields(points, fields)})
+		return
+	}
 	writeJSON(w, http.StatusOK, map[string]any{"points": points})
 }
 
+// configFileInfo describes one of the files the config is loaded from.
+type configFileInfo struct {
+	Name      string   `json:"name"`
+	Format    string   `json:"format"`
+	Processes []string `json:"processes"`
+}
+
+// handleGetConfig returns the config merged from all of its files, with each
+// process from an included file naming it as its source. ?view=files lists
+// the files and the processes each defines instead.
 func (pm *ProcessManager) handleGetConfig(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigRead, nil) {
+		return
+	}
+
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
-	data, err := os.ReadFile(pm.configPath)
+	etag, err := pm.configETagLocked()
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to read config")
 		return
 	}
+	w.Header().Set("ETag", etag)
 
+	if r.URL.Query().Get("view") == "files" {
+		files := make([]configFileInfo, len(pm.configFiles))
+		index := make(map[string]int, len(files))
+		for i, f := range pm.configFiles {
+			files[i] = configFileInfo{Name: f.name, Format: f.format, Processes: []string{}}
+			index[f.name] = i
+		}
+		for _, pc := range pm.cfg.Processes {
+			i := index[pc.Source] // the main config for ""
+			files[i].Processes = append(files[i].Processes, pc.ID)
+		}
+		writeJSON(w, http.StatusOK, files)
+		return
+	}
+
+	// Indented like the file it used to be served as, for smctl config get
+	data, err := json.MarshalIndent(redactAuth(pm.cfg, requestUser(r)), "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode config")
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	w.Write(append(data, '\n'))
 }
 
 func (pm *ProcessManager) handlePutConfig(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigWrite, nil) {
+		return
+	}
+
 	// Limit request body to 1 MB
 	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
 
@@ -419,32 +446,122 @@ func (pm *ProcessManager) handlePutConfig(w http.ResponseWriter, r *http.Request
 	}
 
 	// Validate config
-	if err := validateConfig(&cfg); err != nil {
+	err := validateConfig(&cfg)
+	if err == nil {
+		err = pm.secrets.checkSecretRefs(&cfg)
+	}
+	if err != nil {
+		pm.recordAudit(r, AuditEntry{Action: AuditConfigWrite}, err)
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
-	// Write to disk
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	pm.mu.Lock()
+	etag, err := pm.configETagLocked()
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to marshal config")
+		pm.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, "failed to read config")
 		return
 	}
+	if !checkIfMatch(w, r, etag) {
+		pm.mu.Unlock()
+		return
+	}
+	// Callers who can't see the auth section send the config back without it
+	if cfg.Auth == nil && !requestUser(r).canManageAuth() {
+		cfg.Auth = pm.cfg.Auth
+	}
+	if !authorizeAuthChange(w, r, pm.cfg.Auth, cfg.Auth) {
+		pm.mu.Unlock()
+		return
+	}
+	for _, id := range changedDefinitions(pm.cfg, &cfg) {
+		if pm.scaling[id] {
+			pm.mu.Unlock()
+			writeError(w, http.StatusConflict, "changes to "+id+" are still being applied")
+			return
+		}
+	}
+	entry := AuditEntry{Action: AuditConfigWrite}
+	restart, op, err := pm.applyConfigLocked(requestActor(r), &cfg, configReason(r, "replace config"), entry)
+	if err != nil {
+		pm.mu.Unlock()
+		pm.recordAudit(r, entry, err)
+		var fieldErr *processConfigError
+		if errors.As(err, &fieldErr) {
+			writeConfigError(w, err)
+		} else if errors.Is(err, errConfigConflict) {
+			writeError(w, http.StatusConflict, err.Error())
+		} else if errors.Is(err, errConfigLocked) {
+			writeError(w, http.StatusServiceUnavailable, err.Error())
+		} else {
+			writeError(w, http.StatusInternalServerError, "failed to write config")
+		}
+		return
+	}
+	etag, _ = pm.configETagLocked()
+	pm.mu.Unlock()
 
-	if err := os.WriteFile(pm.configPath, data, 0644); err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to write config")
+	resp := map[string]any{"status": "config updated"}
+	if len(restart) > 0 {
+		resp["restart_required"] = restart
+	}
+	if op != nil {
+		resp["operation"] = pm.ops.snapshot(op)
+	}
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleValidateConfig checks a config document without applying it.
+func (pm *ProcessManager) handleValidateConfig(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigRead, nil) {
 		return
 	}
 
-	// Update in-memory config
-	pm.mu.Lock()
-	pm.cfg = &cfg
-	pm.mu.Unlock()
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+
+	var cfg Config
+	if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	err := validateConfig(&cfg)
+	if err == nil {
+		err = pm.secrets.checkSecretRefs(&cfg)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	writeJSON(w, http.StatusOK, map[string]string{"status": "config updated"})
+	writeJSON(w, http.StatusOK, map[string]any{"valid": true})
+}
+
+func autoRestartChange(id string, before, after bool) ConfigChange {
+	return ConfigChange{
+		Path:   fmt.Sprintf("processes[%s].auto_restart", id),
+		Before: before,
+		After:  after,
+	}
 }
 
 func (pm *ProcessManager) handleGetEvents(w http.ResponseWriter, r *http.Request) {
 	events := pm.events.All()
-	writeJSON(w, http.StatusOK, events)
+
+	user := requestUser(r)
+	visible := make([]Event, 0, len(events))
+	pm.mu.RLock()
+	for _, ev := range events {
+		category := ""
+		if mp, ok := pm.processes[ev.ProcessID]; ok {
+			category = mp.Config().Category
+		}
+		if user.can(PermView, ev.ProcessID, category) {
+			visible = append(visible, ev)
+		}
+	}
+	pm.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, visible)
 }
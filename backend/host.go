@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+const (
+	hostHistoryPath       = "host_history.jsonl"
+	hostHistoryMaxSizeMB  = 5
+	hostHistoryMaxBackups = 1
+	hostHistoryInterval   = time.Minute
+
+	defaultDiskWarnPercent     = 85
+	defaultDiskCriticalPercent = 95
+	diskRecoverMargin          = 2 // percent below a threshold before it clears
+)
+
+// Host events are recorded with an empty process ID and this name.
+const hostEventName = "host"
+
+const (
+	EventDiskWarning   = "disk_warning"
+	EventDiskCritical  = "disk_critical"
+	EventDiskRecovered = "disk_recovered"
+)
+
+// HostConfig sets the disk-space thresholds. They apply to the volumes
+// holding DiskPaths, by default the directory process logs are written to.
+type HostConfig struct {
+	DiskWarnPercent     float64  `json:"disk_warn_percent,omitempty"`
+	DiskCriticalPercent float64  `json:"disk_critical_percent,omitempty"`
+	DiskPaths           []string `json:"disk_paths,omitempty"`
+}
+
+func (hc *HostConfig) thresholds() (warn, critical float64, paths []string) {
+	warn, critical, paths = defaultDiskWarnPercent, defaultDiskCriticalPercent, []string{"."}
+	if hc == nil {
+		return
+	}
+	if hc.DiskWarnPercent > 0 {
+		warn = hc.DiskWarnPercent
+	}
+	if hc.DiskCriticalPercent > 0 {
+		critical = hc.DiskCriticalPercent
+	}
+	if len(hc.DiskPaths) > 0 {
+		paths = hc.DiskPaths
+	}
+	return
+}
+
+func (hc *HostConfig) validate() error {
+	if hc == nil {
+		return nil
+	}
+	if hc.DiskWarnPercent < 0 || hc.DiskWarnPercent > 100 {
+		return fmt.Errorf("host.disk_warn_percent must be between 0 and 100")
+	}
+	if hc.DiskCriticalPercent < 0 || hc.DiskCriticalPercent > 100 {
+		return fmt.Errorf("host.disk_critical_percent must be between 0 and 100")
+	}
+	if warn, critical, _ := hc.thresholds(); warn > critical {
+		return fmt.Errorf("host.disk_warn_percent must not exceed host.disk_critical_percent")
+	}
+	for _, p := range hc.DiskPaths {
+		if p == "" || containsDangerousChars(p) {
+			return fmt.Errorf("invalid host.disk_paths entry: %q", p)
+		}
+	}
+	return nil
+}
+
+// DiskUsage is the usage of one mounted filesystem.
+type DiskUsage struct {
+	Mount       string  `json:"mount"`
+	Device      string  `json:"device"`
+	FSType      string  `json:"fstype"`
+	TotalBytes  uint64  `json:"total_bytes"`
+	UsedBytes   uint64  `json:"used_bytes"`
+	FreeBytes   uint64  `json:"free_bytes"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// HostPoint is one sample of the machine. Network rates are per second over
+// the interval since the previous sample.
+type HostPoint struct {
+	TimestampMS    int64       `json:"timestamp_ms"`
+	CPU            float64     `json:"cpu"` // average over all cores
+	CPUPerCore     []float64   `json:"cpu_per_core"`
+	MemTotalBytes  uint64      `json:"mem_total_bytes"`
+	MemUsedBytes   uint64      `json:"mem_used_bytes"`
+	MemUsedPercent float64     `json:"mem_used_percent"`
+	SwapTotalBytes uint64      `json:"swap_total_bytes"`
+	SwapUsedBytes  uint64      `json:"swap_used_bytes"`
+	Load1          float64     `json:"load1"`
+	Load5          float64     `json:"load5"`
+	Load15         float64     `json:"load15"`
+	Disks          []DiskUsage `json:"disks"`
+	NetRxBps       float64     `json:"net_rx_bps"`
+	NetTxBps       float64     `json:"net_tx_bps"`
+	UptimeSec      uint64      `json:"uptime_sec"`
+}
+
+// HostRingBuffer holds host points in chronological order, dropping the
+// oldest once full.
+type HostRingBuffer struct {
+	points []HostPoint
+	head   int
+	count  int
+	mu     sync.Mutex
+}
+
+func newHostRingBuffer(size int) *HostRingBuffer {
+	return &HostRingBuffer{points: make([]HostPoint, size)}
+}
+
+func (hrb *HostRingBuffer) Push(p HostPoint) {
+	hrb.mu.Lock()
+	defer hrb.mu.Unlock()
+
+	if hrb.count < len(hrb.points) {
+		hrb.count++
+	} else {
+		hrb.head = (hrb.head + 1) % len(hrb.points)
+	}
+	hrb.points[(hrb.head+hrb.count-1)%len(hrb.points)] = p
+}
+
+// Last returns the last n points in chronological order
+func (hrb *HostRingBuffer) Last(n int) []HostPoint {
+	hrb.mu.Lock()
+	defer hrb.mu.Unlock()
+
+	if n > hrb.count {
+		n = hrb.count
+	}
+	result := make([]HostPoint, n)
+	for i := 0; i < n; i++ {
+		result[i] = hrb.points[(hrb.head+hrb.count-n+i)%len(hrb.points)]
+	}
+	return result
+}
+
+// ── Sampling ─────────────────────────────────────────────────────────────────
+
+// diskAlert is the threshold level a watched volume is currently at.
+type diskAlert struct {
+	Mount       string  `json:"mount"`
+	Level       string  `json:"level"` // "warning" or "critical"
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// HostMonitor samples the machine every second into recent, and once a
+// minute into history, which is appended to hostHistoryPath and reloaded
+// on startup.
+type HostMonitor struct {
+	recent  *HostRingBuffer // one hour at one point per second
+	history *HostRingBuffer // one week at one point per minute
+	path    string
+
+	mu          sync.Mutex
+	lastRx      uint64
+	lastTx      uint64
+	lastNetTime time.Time
+	lastSaved   time.Time
+	alerts      map[string]*diskAlert // by mount
+}
+
+func newHostMonitor(path string) *HostMonitor {
+	hm := &HostMonitor{
+		recent:  newHostRingBuffer(3600),
+		history: newHostRingBuffer(7 * 24 * 60),
+		path:    path,
+		alerts:  make(map[string]*diskAlert),
+	}
+	if err := hm.load(); err != nil {
+		log.Printf("[host] failed to load %s: %v", path, err)
+	}
+	return hm
+}
+
+// load fills history from the persisted file and its backup.
+func (hm *HostMonitor) load() error {
+	cutoff := time.Now().Add(-time.Duration(len(hm.history.points)) * hostHistoryInterval).UnixMilli()
+	for _, p := range []string{hm.path + ".1", hm.path} {
+		file, err := os.Open(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var point HostPoint
+			if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+				continue // skip a line torn by a crash
+			}
+			if point.TimestampMS >= cutoff {
+				hm.history.Push(point)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hm *HostMonitor) save(p HostPoint) {
+	line, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	if _, err := rotateLog(hm.path, hostHistoryMaxSizeMB, hostHistoryMaxBackups, 0); err != nil {
+		log.Printf("[host] failed to rotate %s: %v", hm.path, err)
+	}
+	f, err := os.OpenFile(hm.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("[host] failed to open %s: %v", hm.path, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("[host] failed to write history: %v", err)
+	}
+}
+
+// sample reads the current state of the machine. Errors leave the affected
+// fields zero, since not every platform reports everything.
+func (hm *HostMonitor) sample() HostPoint {
+	now := time.Now()
+	p := HostPoint{TimestampMS: now.UnixMilli()}
+
+	if perCore, err := cpu.Percent(0, true); err == nil && len(perCore) > 0 {
+		p.CPUPerCore = perCore
+		for _, c := range perCore {
+			p.CPU += c
+		}
+		p.CPU /= float64(len(perCore))
+	}
+	if vm, err := mem.VirtualMemory(); err == nil {
+		p.MemTotalBytes, p.MemUsedBytes, p.MemUsedPercent = vm.Total, vm.Used, vm.UsedPercent
+	}
+	if sw, err := mem.SwapMemory(); err == nil {
+		p.SwapTotalBytes, p.SwapUsedBytes = sw.Total, sw.Used
+	}
+	if avg, err := load.Avg(); err == nil {
+		p.Load1, p.Load5, p.Load15 = avg.Load1, avg.Load5, avg.Load15
+	}
+	p.Disks = diskUsages()
+	p.UptimeSec, _ = host.Uptime()
+
+	if counters, err := net.IOCounters(true); err == nil {
+		var rx, tx uint64
+		for _, c := range counters {
+			if c.Name == "lo" || strings.HasPrefix(c.Name, "Loopback") {
+				continue
+			}
+			rx += c.BytesRecv
+			tx += c.BytesSent
+		}
+		hm.mu.Lock()
+		if elapsed := now.Sub(hm.lastNetTime).Seconds(); !hm.lastNetTime.IsZero() && elapsed > 0 {
+			p.NetRxBps = float64(sub(rx, hm.lastRx)) / elapsed
+			p.NetTxBps = float64(sub(tx, hm.lastTx)) / elapsed
+		}
+		hm.lastRx, hm.lastTx, hm.lastNetTime = rx, tx, now
+		hm.mu.Unlock()
+	}
+	return p
+}
+
+// diskUsages lists every mounted physical filesystem once.
+func diskUsages() []DiskUsage {
+	parts, err := disk.Partitions(false)
+	if err != nil {
+		return nil
+	}
+	disks := make([]DiskUsage, 0, len(parts))
+	seen := make(map[string]bool)
+	for _, part := range parts {
+		if seen[part.Mountpoint] {
+			continue
+		}
+		seen[part.Mountpoint] = true
+		u, err := disk.Usage(part.Mountpoint)
+		if err != nil || u.Total == 0 {
+			continue
+		}
+		disks = append(disks, DiskUsage{
+			Mount:       part.Mountpoint,
+			Device:      part.Device,
+			FSType:      part.Fstype,
+			TotalBytes:  u.Total,
+			UsedBytes:   u.Used,
+			FreeBytes:   u.Free,
+			UsedPercent: u.UsedPercent,
+		})
+	}
+	return disks
+}
+
+// watchedDisks returns the usage of the volumes holding paths: the
+// longest matching mount point, or the path itself when none matches.
+func watchedDisks(disks []DiskUsage, paths []string) []DiskUsage {
+	var out []DiskUsage
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = resolved
+		}
+		best := -1
+		for i, d := range disks {
+			if pathWithin(abs, d.Mount) && (best < 0 || len(d.Mount) > len(disks[best].Mount)) {
+				best = i
+			}
+		}
+		var d DiskUsage
+		if best >= 0 {
+			d = disks[best]
+		} else if u, err := disk.Usage(abs); err == nil {
+			d = DiskUsage{Mount: abs, FSType: u.Fstype, TotalBytes: u.Total, UsedBytes: u.Used, FreeBytes: u.Free, UsedPercent: u.UsedPercent}
+		} else {
+			continue
+		}
+		if !seen[d.Mount] {
+			seen[d.Mount] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func pathWithin(path, mount string) bool {
+	rel, err := filepath.Rel(mount, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// checkDisks compares watched volumes against the thresholds and returns
+// the events to record, one per level change. A level only clears once
+// usage drops diskRecoverMargin below it, so usage hovering at a
+// threshold does not flood the event log.
+func (hm *HostMonitor) checkDisks(disks []DiskUsage, warn, critical float64) []Event {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	var events []Event
+	current := make(map[string]bool)
+	for _, d := range disks {
+		current[d.Mount] = true
+		prev := ""
+		if a, ok := hm.alerts[d.Mount]; ok {
+			prev = a.Level
+		}
+
+		level := ""
+		switch {
+		case d.UsedPercent >= critical || (prev == "critical" && d.UsedPercent >= critical-diskRecoverMargin):
+			level = "critical"
+		case d.UsedPercent >= warn || (prev != "" && d.UsedPercent >= warn-diskRecoverMargin):
+			level = "warning"
+		}
+
+		if level == "" {
+			delete(hm.alerts, d.Mount)
+		} else {
+			hm.alerts[d.Mount] = &diskAlert{Mount: d.Mount, Level: level, UsedPercent: d.UsedPercent}
+		}
+		if level == prev {
+			continue
+		}
+
+		reason := fmt.Sprintf("%s is %.1f%% full (%s free)", d.Mount, d.UsedPercent, formatBytes(d.FreeBytes))
+		switch level {
+		case "critical":
+			events = append(events, Event{Type: EventDiskCritical, Reason: reason})
+		case "warning":
+			events = append(events, Event{Type: EventDiskWarning, Reason: reason})
+		default:
+			events = append(events, Event{Type: EventDiskRecovered, Reason: reason})
+		}
+	}
+	// Volumes no longer watched drop their alerts silently
+	for mount := range hm.alerts {
+		if !current[mount] {
+			delete(hm.alerts, mount)
+		}
+	}
+	return events
+}
+
+func (hm *HostMonitor) activeAlerts() []diskAlert {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	out := make([]diskAlert, 0, len(hm.alerts))
+	for _, a := range hm.alerts {
+		out = append(out, *a)
+	}
+	return out
+}
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return strconv.FormatUint(n, 10) + " B"
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// hostMonitor samples the host once a second, broadcasting each point and
+// recording disk threshold events.
+func (pm *ProcessManager) hostMonitor() {
+	ticker := time.NewTicker(1 * time.Second)
+	for range ticker.C {
+		hm := pm.host
+		point := hm.sample()
+		hm.recent.Push(point)
+
+		if time.Since(hm.lastSaved) >= hostHistoryInterval {
+			hm.lastSaved = time.Now()
+			hm.history.Push(point)
+			hm.save(point)
+		}
+
+		pm.mu.RLock()
+		warn, critical, paths := pm.cfg.Host.thresholds()
+		pm.mu.RUnlock()
+		for _, ev := range hm.checkDisks(watchedDisks(point.Disks, paths), warn, critical) {
+			log.Printf("[host] %s: %s", ev.Type, ev.Reason)
+			pm.events.RecordReason("", hostEventName, ev.Type, ev.Reason)
+		}
+
+		pm.hub.publish(wsMessage{
+			topic: TopicHost,
+			render: func(u *User) any {
+				if !u.can(PermView, "", "") {
+					return nil
+				}
+				return point
+			},
+		})
+	}
+}
+
+// handleGetHost returns the latest host point with recent history. By
+// default the last ?minutes= (up to 60) of per-second points are returned;
+// ?hours= (up to 168) returns the persisted per-minute history instead.
+func (pm *ProcessManager) handleGetHost(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermView, nil) {
+		return
+	}
+
+	q := r.URL.Query()
+	var points []HostPoint
+	if s := q.Get("hours"); s != "" {
+		hours, err := strconv.Atoi(s)
+		if err != nil || hours <= 0 || hours > 168 {
+			writeError(w, http.StatusBadRequest, "hours must be between 1 and 168")
+			return
+		}
+		points = pm.host.history.Last(hours * 60)
+	} else {
+		minutes := 5
+		if s := q.Get("minutes"); s != "" {
+			if n, err := strconv.Atoi(s); err == nil && n > 0 && n <= 60 {
+				minutes = n
+			}
+		}
+		points = pm.host.recent.Last(minutes * 60)
+	}
+
+	var current *HostPoint
+	if last := pm.host.recent.Last(1); len(last) == 1 {
+		current = &last[0]
+	}
+	pm.mu.RLock()
+	warn, critical, _ := pm.cfg.Host.thresholds()
+	pm.mu.RUnlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"current":     current,
+		"points":      points,
+		"disk_alerts": pm.host.activeAlerts(),
+		"thresholds":  map[string]float64{"disk_warn_percent": warn, "disk_critical_percent": critical},
+	})
+}
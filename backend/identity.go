@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+// launcherArg is the first argument the manager binary recognises when it
+// re-executes itself to prepare a child's identity and rlimits before exec.
+const launcherArg = "__launch"
+
+// launchSpec tells the launcher what to apply before exec'ing the target.
+type launchSpec struct {
+	Chroot     string   `json:"chroot,omitempty"`
+	Dir        string   `json:"dir,omitempty"` // inside the chroot
+	SetIDs     bool     `json:"set_ids,omitempty"`
+	UID        uint32   `json:"uid,omitempty"`
+	GID        uint32   `json:"gid,omitempty"`
+	Groups     []uint32 `json:"groups,omitempty"`
+	Umask      int      `json:"umask"` // -1 leaves it unchanged
+	NoNewPrivs bool     `json:"no_new_privs,omitempty"`
+	NoFile     uint64   `json:"nofile,omitempty"`
+	CoreMax    *uint64  `json:"core_max,omitempty"` // bytes
+	StatusFD   int      `json:"status_fd,omitempty"`
+}
+
+// launchStatus is the manager's end of the pipe the launcher reports setup
+// failures on. The launcher's end closes on exec, so an empty read means
+// the target is running with everything applied.
+type launchStatus struct {
+	r, w *os.File
+}
+
+// wait blocks until the launcher has exec'd the target or failed, returning
+// its error. It must be called after cmd.Start.
+func (ls *launchStatus) wait() error {
+	if ls == nil {
+		return nil
+	}
+	ls.w.Close()
+	msg, _ := io.ReadAll(ls.r)
+	ls.r.Close()
+	if len(msg) > 0 {
+		return errors.New(string(msg))
+	}
+	return nil
+}
+
+// close releases the pipe when the process is not started after all.
+func (ls *launchStatus) close() {
+	if ls != nil {
+		ls.w.Close()
+		ls.r.Close()
+	}
+}
+
+// hasIdentity reports whether a process asks for any identity or sandbox
+// settings.
+func (pc *ProcessConfig) hasIdentity() bool {
+	return pc.User != "" || pc.Group != "" || len(pc.SupplementaryGroups) > 0 ||
+		pc.Umask != "" || pc.Chroot != "" || pc.NoNewPrivileges
+}
+
+// parseUmask reads an octal umask such as "027" or "0027".
+func parseUmask(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("umask must be an octal value between 000 and 777")
+	}
+	return int(n), nil
+}
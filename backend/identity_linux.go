@@ -0,0 +1,276 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// processIdentity is the resolved account a process runs as.
+type processIdentity struct {
+	uid, gid uint32
+	groups   []uint32
+}
+
+func lookupAccount(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroupID(name string) (uint32, error) {
+	var g *user.Group
+	var err error
+	if _, convErr := strconv.Atoi(name); convErr == nil {
+		g, err = user.LookupGroupId(name)
+	} else {
+		g, err = user.LookupGroup(name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(id), err
+}
+
+// resolveIdentity looks up the configured user and groups. It returns nil
+// when the process keeps the manager's identity. Without an explicit list,
+// supplementary groups are the user's own, as login would set them.
+func resolveIdentity(pc *ProcessConfig) (*processIdentity, error) {
+	if pc.User == "" && pc.Group == "" && len(pc.SupplementaryGroups) == 0 {
+		return nil, nil
+	}
+
+	id := &processIdentity{uid: uint32(os.Getuid()), gid: uint32(os.Getgid())}
+	var u *user.User
+	if pc.User != "" {
+		var err error
+		if u, err = lookupAccount(pc.User); err != nil {
+			return nil, fmt.Errorf("%s: unknown user %s", pc.ID, pc.User)
+		}
+		uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+		gid, _ := strconv.ParseUint(u.Gid, 10, 32)
+		id.uid, id.gid = uint32(uid), uint32(gid)
+	}
+	if pc.Group != "" {
+		gid, err := lookupGroupID(pc.Group)
+		if err != nil {
+			return nil, fmt.Errorf("%s: unknown group %s", pc.ID, pc.Group)
+		}
+		id.gid = gid
+	}
+
+	switch {
+	case len(pc.SupplementaryGroups) > 0:
+		for _, name := range pc.SupplementaryGroups {
+			gid, err := lookupGroupID(name)
+			if err != nil {
+				return nil, fmt.Errorf("%s: unknown supplementary group %s", pc.ID, name)
+			}
+			id.groups = append(id.groups, gid)
+		}
+	case u != nil:
+		gids, err := u.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("%s: cannot list groups of %s: %w", pc.ID, pc.User, err)
+		}
+		for _, g := range gids {
+			if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+				id.groups = append(id.groups, uint32(gid))
+			}
+		}
+	}
+	return id, nil
+}
+
+func validateIdentity(pc *ProcessConfig) error {
+	if !pc.hasIdentity() {
+		return nil
+	}
+	if pc.IsService {
+		return fmt.Errorf("%s: user and sandbox settings are not supported for services", pc.ID)
+	}
+	if _, err := resolveIdentity(pc); err != nil {
+		return err
+	}
+	if _, err := parseUmask(pc.Umask); err != nil {
+		return fmt.Errorf("%s: %w", pc.ID, err)
+	}
+	if pc.Chroot != "" {
+		if !filepath.IsAbs(pc.Chroot) || containsDangerousChars(pc.Chroot) {
+			return fmt.Errorf("%s: chroot must be an absolute path", pc.ID)
+		}
+		if info, err := os.Stat(pc.Chroot); err != nil || !info.IsDir() {
+			return fmt.Errorf("%s: chroot directory %s does not exist", pc.ID, pc.Chroot)
+		}
+		if !filepath.IsAbs(pc.Executable) {
+			return fmt.Errorf("%s: executable must be an absolute path inside the chroot", pc.ID)
+		}
+	}
+	return nil
+}
+
+// applyIdentity arranges for cmd to run with the configured identity and
+// rlimits. User, groups and chroot map directly onto SysProcAttr; umask,
+// no_new_privileges and rlimits have no SysProcAttr equivalent, so those
+// processes are started through the manager's own binary acting as a
+// launcher, which applies everything and then execs the target in place
+// (same PID). The returned status reports whether the launcher succeeded.
+func applyIdentity(pc *ProcessConfig, cmd *exec.Cmd) (*processIdentity, *launchStatus, error) {
+	rlimits := pc.Limits.needsRlimits()
+	if !pc.hasIdentity() && !rlimits {
+		return nil, nil, nil
+	}
+	id, err := resolveIdentity(pc)
+	if err != nil {
+		return nil, nil, err
+	}
+	umask, err := parseUmask(pc.Umask)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	if umask < 0 && !pc.NoNewPrivileges && !rlimits {
+		if id != nil {
+			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: id.uid, Gid: id.gid, Groups: id.groups}
+		}
+		cmd.SysProcAttr.Chroot = pc.Chroot
+		return id, nil, nil
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot locate launcher: %w", err)
+	}
+	spec := launchSpec{Chroot: pc.Chroot, Umask: umask, NoNewPrivs: pc.NoNewPrivileges}
+	if pc.Chroot != "" {
+		// The launcher chroots first, so the working dir is resolved inside
+		spec.Dir, cmd.Dir = pc.WorkingDir, ""
+	}
+	if id != nil {
+		spec.SetIDs, spec.UID, spec.GID, spec.Groups = true, id.uid, id.gid, id.groups
+	}
+	if l := pc.Limits; rlimits {
+		spec.NoFile = l.NoFile
+		if l.CoreMaxMB != nil {
+			size := uint64(*l.CoreMaxMB) * 1024 * 1024
+			spec.CoreMax = &size
+		}
+	}
+
+	target := cmd.Path
+	if pc.Chroot != "" {
+		target = pc.Executable
+	} else if cmd.Err != nil {
+		return nil, nil, cmd.Err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+	spec.StatusFD = 2 + len(cmd.ExtraFiles)
+	specJSON, err := json.Marshal(spec)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, nil, err
+	}
+	cmd.Args = append([]string{self, launcherArg, string(specJSON), "--", target}, cmd.Args[1:]...)
+	cmd.Path, cmd.Err = self, nil
+	return id, &launchStatus{r: r, w: w}, nil
+}
+
+// chownLog hands a process's log file to its user so it can read it back.
+func chownLog(f *os.File, id *processIdentity) error {
+	if id == nil {
+		return nil
+	}
+	return f.Chown(int(id.uid), int(id.gid))
+}
+
+// runLauncher is the body of "server-manager __launch <spec> -- exe args...".
+// It never returns.
+func runLauncher(args []string) {
+	var status *os.File
+	fail := func(format string, a ...any) {
+		msg := fmt.Sprintf(format, a...)
+		fmt.Fprintln(os.Stderr, "[launcher] "+msg)
+		if status != nil {
+			status.WriteString(msg)
+		}
+		os.Exit(127)
+	}
+	if len(args) < 3 || args[1] != "--" {
+		fail("usage: %s <spec> -- <executable> [args...]", launcherArg)
+	}
+	var spec launchSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		fail("invalid spec: %v", err)
+	}
+	if spec.StatusFD > 0 {
+		// Closed by the exec below, which tells the manager it worked
+		syscall.CloseOnExec(spec.StatusFD)
+		status = os.NewFile(uintptr(spec.StatusFD), "launch-status")
+	}
+	argv := args[2:]
+
+	// Before switching users, who may not raise their own hard limits
+	if err := setRlimits(&spec); err != nil {
+		fail("%v", err)
+	}
+
+	if spec.Chroot != "" {
+		if err := syscall.Chroot(spec.Chroot); err != nil {
+			fail("chroot %s: %v", spec.Chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			fail("chdir: %v", err)
+		}
+	}
+	if spec.SetIDs {
+		groups := make([]int, len(spec.Groups))
+		for i, g := range spec.Groups {
+			groups[i] = int(g)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			fail("setgroups: %v", err)
+		}
+		if err := syscall.Setgid(int(spec.GID)); err != nil {
+			fail("setgid: %v", err)
+		}
+		if err := syscall.Setuid(int(spec.UID)); err != nil {
+			fail("setuid: %v", err)
+		}
+	}
+	if spec.Umask >= 0 {
+		syscall.Umask(spec.Umask)
+	}
+	if spec.NoNewPrivs {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			fail("no_new_privs: %v", err)
+		}
+	}
+	if spec.Dir != "" {
+		if err := os.Chdir(spec.Dir); err != nil {
+			fail("chdir %s: %v", spec.Dir, err)
+		}
+	}
+
+	err := syscall.Exec(argv[0], argv, os.Environ())
+	fail("exec %s: %v", argv[0], err)
+}
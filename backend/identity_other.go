@@ -0,0 +1,32 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// processIdentity is a placeholder; switching users is Linux-only.
+type processIdentity struct{}
+
+func validateIdentity(pc *ProcessConfig) error {
+	if pc.hasIdentity() {
+		return fmt.Errorf("%s: user, group, umask, chroot and no_new_privileges are only supported on Linux", pc.ID)
+	}
+	return nil
+}
+
+func applyIdentity(pc *ProcessConfig, cmd *exec.Cmd) (*processIdentity, *launchStatus, error) {
+	return nil, nil, validateIdentity(pc)
+}
+
+func chownLog(f *os.File, id *processIdentity) error {
+	return nil
+}
+
+func runLauncher(args []string) {
+	fmt.Fprintln(os.Stderr, "launcher is only supported on Linux")
+	os.Exit(127)
+}
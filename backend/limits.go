@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// CrashReasonOOM marks a crash caused by the kernel OOM killer enforcing
+// the process's memory limit.
+const CrashReasonOOM = "oom_killed"
+
+// ResourceLimits caps what a process may consume. Limits are enforced on
+// Linux only: rlimits by the launcher before exec and the rest via a
+// per-process cgroup v2.
+type ResourceLimits struct {
+	MemoryMaxMB int     `json:"memory_max_mb,omitempty"`
+	CPUQuota    float64 `json:"cpu_quota,omitempty"`  // in cores, e.g. 1.5
+	CPUWeight   int     `json:"cpu_weight,omitempty"` // 1-10000, kernel default 100
+	PidsMax     int     `json:"pids_max,omitempty"`
+	NoFile      uint64  `json:"nofile,omitempty"`      // max open files
+	CoreMaxMB   *int64  `json:"core_max_mb,omitempty"` // 0 disables core dumps
+}
+
+// needsCgroup reports whether any limit is enforced through a cgroup.
+func (l *ResourceLimits) needsCgroup() bool {
+	return l != nil && (l.MemoryMaxMB > 0 || l.CPUQuota > 0 || l.CPUWeight > 0 || l.PidsMax > 0)
+}
+
+// needsRlimits reports whether any limit is set as an rlimit, which the
+// launcher applies before exec.
+func (l *ResourceLimits) needsRlimits() bool {
+	return l != nil && (l.NoFile > 0 || l.CoreMaxMB != nil)
+}
+
+// exitReason describes why a process exited on its own: an OOM kill within
+// its cgroup, otherwise the exit status or signal.
+func exitReason(cmd *exec.Cmd, cg *processCgroup) string {
+	if cg != nil && cg.oomKilled() {
+		return CrashReasonOOM
+	}
+	if cmd.ProcessState != nil {
+		return cmd.ProcessState.String()
+	}
+	return ""
+}
+
+func (l *ResourceLimits) validate(id string) error {
+	if l == nil {
+		return nil
+	}
+	switch {
+	case l.MemoryMaxMB < 0:
+		return fmt.Errorf("%s: limits.memory_max_mb must be >= 0", id)
+	case l.CPUQuota < 0:
+		return fmt.Errorf("%s: limits.cpu_quota must be >= 0", id)
+	case l.CPUWeight != 0 && (l.CPUWeight < 1 || l.CPUWeight > 10000):
+		return fmt.Errorf("%s: limits.cpu_weight must be between 1 and 10000", id)
+	case l.PidsMax < 0:
+		return fmt.Errorf("%s: limits.pids_max must be >= 0", id)
+	case l.CoreMaxMB != nil && *l.CoreMaxMB < 0:
+		return fmt.Errorf("%s: limits.core_max_mb must be >= 0", id)
+	}
+	return nil
+}
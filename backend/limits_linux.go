@@ -0,0 +1,222 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	cgroupMount       = "/sys/fs/cgroup"
+	defaultCgroupRoot = cgroupMount + "/server-manager.slice"
+	cpuPeriodUSec     = 100000
+)
+
+var (
+	cgroupRootOnce sync.Once
+	cgroupRootPath string
+	cgroupRootErr  error
+)
+
+// processCgroup is the cgroup v2 a single process (and all its children)
+// runs in, along with state for turning counters into rates.
+type processCgroup struct {
+	path      string
+	oomBase   uint64
+	lastUsage uint64 // cpu.stat usage_usec at lastTime
+	lastTime  time.Time
+}
+
+// cgroupRoot returns the manager-owned slice that per-process cgroups are
+// created under, creating it and delegating controllers on first use.
+func cgroupRoot() (string, error) {
+	cgroupRootOnce.Do(func() {
+		if _, err := os.Stat(filepath.Join(cgroupMount, "cgroup.controllers")); err != nil {
+			cgroupRootErr = errors.New("cgroup v2 is not mounted at " + cgroupMount)
+			return
+		}
+		root := firstNonEmpty(os.Getenv("SM_CGROUP_ROOT"), defaultCgroupRoot)
+		if err := os.MkdirAll(root, 0755); err != nil {
+			cgroupRootErr = fmt.Errorf("failed to create %s: %w", root, err)
+			return
+		}
+		// The parent may already delegate these; only the slice's own
+		// subtree_control is required to succeed.
+		enableControllers(filepath.Dir(root))
+		if err := enableControllers(root); err != nil {
+			cgroupRootErr = fmt.Errorf("failed to enable controllers in %s: %w", root, err)
+			return
+		}
+		cgroupRootPath = root
+	})
+	return cgroupRootPath, cgroupRootErr
+}
+
+func enableControllers(dir string) error {
+	return os.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), 0644)
+}
+
+// prepareLimits creates the process's cgroup, writes its limits and arranges
+// for cmd to be started directly inside it. The returned release func must
+// be called once cmd.Start has returned.
+func prepareLimits(pc *ProcessConfig, cmd *exec.Cmd) (*processCgroup, func(), error) {
+	noop := func() {}
+	if !pc.Limits.needsCgroup() {
+		return nil, noop, nil
+	}
+	root, err := cgroupRoot()
+	if err != nil {
+		return nil, noop, fmt.Errorf("cgroup limits unavailable: %w", err)
+	}
+
+	cg := &processCgroup{path: filepath.Join(root, pc.ID)}
+	if err := os.Mkdir(cg.path, 0755); err != nil && !os.IsExist(err) {
+		return nil, noop, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+
+	l := pc.Limits
+	settings := map[string]string{
+		"memory.max": "max",
+		"cpu.max":    "max " + strconv.Itoa(cpuPeriodUSec),
+		"cpu.weight": "100",
+		"pids.max":   "max",
+	}
+	if l.MemoryMaxMB > 0 {
+		settings["memory.max"] = strconv.FormatInt(int64(l.MemoryMaxMB)*1024*1024, 10)
+	}
+	if l.CPUQuota > 0 {
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int64(l.CPUQuota*cpuPeriodUSec), cpuPeriodUSec)
+	}
+	if l.CPUWeight > 0 {
+		settings["cpu.weight"] = strconv.Itoa(l.CPUWeight)
+	}
+	if l.PidsMax > 0 {
+		settings["pids.max"] = strconv.Itoa(l.PidsMax)
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(cg.path, file), []byte(value), 0644); err != nil {
+			return nil, noop, fmt.Errorf("failed to set %s: %w", file, err)
+		}
+	}
+	cg.oomBase = cg.oomKills()
+
+	dir, err := os.Open(cg.path)
+	if err != nil {
+		return nil, noop, err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// Start the child inside the cgroup (clone3, Linux 5.7+) so nothing it
+	// forks can escape accounting.
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	return cg, func() { dir.Close() }, nil
+}
+
+// setRlimits applies the launch spec's rlimits to the calling process, to be
+// inherited across exec. syscall.Setrlimit is used so the Go runtime does
+// not restore its own NOFILE limit on exec.
+func setRlimits(spec *launchSpec) error {
+	if spec.NoFile > 0 {
+		lim := syscall.Rlimit{Cur: spec.NoFile, Max: spec.NoFile}
+		if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &lim); err != nil {
+			return fmt.Errorf("failed to set nofile limit: %w", err)
+		}
+	}
+	if spec.CoreMax != nil {
+		lim := syscall.Rlimit{Cur: *spec.CoreMax, Max: *spec.CoreMax}
+		if err := syscall.Setrlimit(syscall.RLIMIT_CORE, &lim); err != nil {
+			return fmt.Errorf("failed to set core limit: %w", err)
+		}
+	}
+	return nil
+}
+
+// sample returns CPU usage (percent of one core since the previous sample)
+// and memory for everything in the cgroup.
+func (cg *processCgroup) sample() (cpu float64, mem uint64, ok bool) {
+	usage, err := readCgroupStat(filepath.Join(cg.path, "cpu.stat"), "usage_usec")
+	if err != nil {
+		return 0, 0, false
+	}
+	data, err := os.ReadFile(filepath.Join(cg.path, "memory.current"))
+	if err != nil {
+		return 0, 0, false
+	}
+	mem, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	now := time.Now()
+	if !cg.lastTime.IsZero() && usage >= cg.lastUsage {
+		elapsed := now.Sub(cg.lastTime).Microseconds()
+		if elapsed > 0 {
+			cpu = float64(usage-cg.lastUsage) / float64(elapsed) * 100
+		}
+	}
+	cg.lastUsage, cg.lastTime = usage, now
+	return cpu, mem, true
+}
+
+func (cg *processCgroup) oomKills() uint64 {
+	n, _ := readCgroupStat(filepath.Join(cg.path, "memory.events"), "oom_kill")
+	return n
+}
+
+// oomKilled reports whether the OOM killer fired in the cgroup since start.
+func (cg *processCgroup) oomKilled() bool {
+	return cg.oomKills() > cg.oomBase
+}
+
+// kill SIGKILLs everything in the cgroup (cgroup.kill, Linux 5.14+).
+func (cg *processCgroup) kill() {
+	os.WriteFile(filepath.Join(cg.path, "cgroup.kill"), []byte("1"), 0644)
+}
+
+// remove deletes the cgroup once everything in it has exited. cgroup.kill
+// does not wait for the kills, so removal is retried briefly.
+func (cg *processCgroup) remove() {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := os.Remove(cg.path)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("[limits] failed to remove cgroup %s: %v", cg.path, err)
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// readCgroupStat reads one "key value" line from a flat-keyed cgroup file.
+func readCgroupStat(path, key string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), " ")
+		if ok && k == key {
+			return strconv.ParseUint(v, 10, 64)
+		}
+	}
+	return 0, fmt.Errorf("%s: no %s", path, key)
+}
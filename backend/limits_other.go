@@ -0,0 +1,30 @@
+//go:build !linux
+
+package main
+
+import (
+	"log"
+	"os/exec"
+)
+
+// processCgroup is a placeholder; cgroups only exist on Linux.
+type processCgroup struct{}
+
+func prepareLimits(pc *ProcessConfig, cmd *exec.Cmd) (*processCgroup, func(), error) {
+	if pc.Limits != nil {
+		log.Printf("[limits] Resource limits are only enforced on Linux; ignoring them for %s", pc.ID)
+	}
+	return nil, func() {}, nil
+}
+
+func (cg *processCgroup) sample() (cpu float64, mem uint64, ok bool) {
+	return 0, 0, false
+}
+
+func (cg *processCgroup) oomKilled() bool {
+	return false
+}
+
+func (cg *processCgroup) kill() {}
+
+func (cg *processCgroup) remove() {}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	logFollowInterval = 500 * time.Millisecond
+	logFollowMaxRead  = 256 * 1024 // per process per interval
+)
+
+// logFollower remembers how far a process's log has been streamed.
+type logFollower struct {
+	offset  int64
+	partial []byte // an unterminated last line, held until it completes
+}
+
+// followLogs streams new log lines to logs:{id} subscribers. A log is only
+// read while someone is subscribed to it, starting from its end at the time
+// of the first subscription.
+func (pm *ProcessManager) followLogs() {
+	followers := make(map[string]*logFollower)
+	ticker := time.NewTicker(logFollowInterval)
+	for range ticker.C {
+		active := make(map[string]bool)
+		for _, topic := range pm.hub.subscribedWithPrefix(TopicLogsPrefix) {
+			id := strings.TrimPrefix(topic, TopicLogsPrefix)
+			active[id] = true
+
+			pm.mu.RLock()
+			mp, ok := pm.processes[id]
+			pm.mu.RUnlock()
+			if !ok || mp.Config().IsService {
+				continue
+			}
+
+			path := fmt.Sprintf("./%s.log", id)
+			f, ok := followers[id]
+			if !ok {
+				f = &logFollower{}
+				if info, err := os.Stat(path); err == nil {
+					f.offset = info.Size()
+				}
+				followers[id] = f
+				continue
+			}
+			lines := f.read(path)
+			if len(lines) == 0 {
+				continue
+			}
+			for i, line := range lines {
+				lines[i] = pm.secrets.Redact(line)
+			}
+			pc := *mp.Config()
+			pm.hub.publish(wsMessage{topic: topic, render: func(u *User) any {
+				if !u.canProcess(PermLogsRead, &pc) {
+					return nil
+				}
+				return map[string][]string{"lines": lines}
+			}})
+		}
+		for id := range followers {
+			if !active[id] {
+				delete(followers, id)
+			}
+		}
+	}
+}
+
+// read returns the complete lines appended since the last call. A file
+// that shrank was rotated or truncated and is read from the start.
+func (f *logFollower) read(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil
+	}
+	if info.Size() < f.offset {
+		f.offset, f.partial = 0, nil
+	}
+	if info.Size() == f.offset {
+		return nil
+	}
+	if _, err := file.Seek(f.offset, io.SeekStart); err != nil {
+		return nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(file, logFollowMaxRead))
+	if err != nil {
+		return nil
+	}
+	f.offset += int64(len(buf))
+
+	data := append(f.partial, buf...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		if len(data) < logFollowMaxRead {
+			f.partial = data
+			return nil
+		}
+		end = len(data) // an overlong line is sent in pieces
+		data = append(data, '\n')
+	}
+	f.partial = append([]byte(nil), data[end+1:]...)
+
+	var lines []string
+	for _, l := range bytes.Split(data[:end], []byte("\n")) {
+		lines = append(lines, sanitizeLine(bytes.TrimRight(l, "\r")))
+	}
+	return lines
+}
@@ -1,49 +1,99 @@
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
+	"os"
 )
 
-const configPath = "config.json"
-
 func main() {
-	cfg, err := loadConfig(configPath)
+	if len(os.Args) > 1 && os.Args[1] == launcherArg {
+		runLauncher(os.Args[2:])
+	}
+
+	flags := parseServerFlags()
+	configPath := flags.configPath
+
+	if configPath == defaultConfigNames[0] {
+		configPath = findDefaultConfig()
+	}
+
+	cfg, files, recovered, err := loadConfigFiles(configPath, true)
+	if err != nil {
+		log.Fatalf("failed to load %s: %v", configPath, err)
+	}
+	if err := validateConfig(cfg); err != nil {
+		log.Fatalf("invalid config %s: %v", configPath, err)
+	}
+	sc := flags.resolve(cfg.Server)
+
+	secrets, err := openSecretStore(secretsPath,
+		firstNonEmpty(os.Getenv("SM_SECRETS_KEY_FILE"), secretsKeyPath),
+		os.Getenv("SM_SECRETS_PASSPHRASE"))
 	if err != nil {
-		log.Fatalf("failed to load config.json: %v", err)
+		log.Fatalf("failed to open secrets store: %v", err)
+	}
+	if err := secrets.checkSecretRefs(cfg); err != nil {
+		log.Printf("[secrets] Warning: %v", err)
 	}
 
-	pm := newProcessManager(cfg, configPath)
+	pm := newProcessManager(cfg, configPath, secrets)
+	pm.configFiles = files
+	pm.fileSum = sumConfigFiles(files)
+	if data, err := json.Marshal(cfg); err == nil {
+		pm.history.Sync(data)
+	}
+	for _, msg := range recovered {
+		pm.events.RecordReason("", configEventName, EventConfigRecovered, msg)
+	}
+	pm.watchConfig()
 	pm.run()
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /api/processes", pm.handleGetProcesses)
+	mux.HandleFunc("POST /api/processes", pm.handleCreateProcess)
 	mux.HandleFunc("POST /api/processes/start-all", pm.handleStartAll)
 	mux.HandleFunc("POST /api/processes/stop-all", pm.handleStopAll)
+	mux.HandleFunc("POST /api/processes/restart-all", pm.handleRestartAll)
 	mux.HandleFunc("GET /api/processes/{id}/start", pm.handleStart)
 	mux.HandleFunc("POST /api/processes/{id}/start", pm.handleStart)
 	mux.HandleFunc("POST /api/processes/{id}/stop", pm.handleStop)
+	mux.HandleFunc("POST /api/processes/{id}/restart", pm.handleRestart)
 	mux.HandleFunc("GET /api/processes/{id}/metrics", pm.handleGetMetrics)
 	mux.HandleFunc("PUT /api/processes/{id}/autorestart", pm.handleToggleAutoRestart)
+	mux.HandleFunc("GET /api/processes/{id}/env", pm.handleGetEnv)
+	mux.HandleFunc("GET /api/processes/{id}/tree", pm.handleGetTree)
 	mux.HandleFunc("GET /api/processes/{id}/logs", pm.handleGetLogs)
+	mux.HandleFunc("POST /api/processes/{id}/console", pm.handleConsoleInput)
+	mux.HandleFunc("GET /api/processes/{id}/config", pm.handleGetProcessConfig)
+	mux.HandleFunc("PATCH /api/processes/{id}/config", pm.handlePatchProcessConfig)
+	mux.HandleFunc("DELETE /api/processes/{id}/config", pm.handleDeleteProcessConfig)
+	mux.HandleFunc("POST /api/groups/{id}/start", pm.handleGroupStart)
+	mux.HandleFunc("POST /api/groups/{id}/stop", pm.handleGroupStop)
+	mux.HandleFunc("POST /api/groups/{id}/scale", pm.handleScaleGroup)
 	mux.HandleFunc("GET /api/config", pm.handleGetConfig)
 	mux.HandleFunc("PUT /api/config", pm.handlePutConfig)
+	mux.HandleFunc("POST /api/config/validate", pm.handleValidateConfig)
+	mux.HandleFunc("GET /api/config/history", pm.handleGetConfigHistory)
+	mux.HandleFunc("GET /api/config/history/{v}/diff", pm.handleConfigHistoryDiff)
+	mux.HandleFunc("POST /api/config/rollback/{v}", pm.handleConfigRollback)
+	mux.HandleFunc("GET /api/operations", pm.handleListOperations)
+	mux.HandleFunc("GET /api/operations/{id}", pm.handleGetOperation)
+	mux.HandleFunc("POST /api/operations/{id}/cancel", pm.handleCancelOperation)
+	mux.HandleFunc("GET /api/secrets", pm.handleListSecrets)
+	mux.HandleFunc("PUT /api/secrets/{name}", pm.handlePutSecret)
+	mux.HandleFunc("DELETE /api/secrets/{name}", pm.handleDeleteSecret)
+	mux.HandleFunc("GET /api/host", pm.handleGetHost)
 	mux.HandleFunc("GET /api/events", pm.handleGetEvents)
+	mux.HandleFunc("GET /api/audit", pm.handleGetAudit)
+	mux.HandleFunc("GET /api/stream", pm.handleStream)
+	mux.HandleFunc("GET /api/admin/ws-clients", pm.handleGetWSClients)
 	mux.HandleFunc("/ws", pm.handleWS)
+	mux.Handle("/", newWebHandler(firstNonEmpty(flags.webDir, os.Getenv("SM_WEB_DIR"))))
 
-	log.Println("Server manager backend running on http://localhost:8090")
-	log.Fatal(http.ListenAndServe(":8090", corsMiddleware(mux)))
-}
+	cors := &corsPolicy{origins: sc.CORSOrigins}
+	upgrader.CheckOrigin = cors.checkWSOrigin
 
-func corsMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "http://localhost:5173")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+	log.Fatal(serve(sc, cors.middleware(pm.authMiddleware(mux))))
 }
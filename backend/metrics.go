@@ -1,14 +1,113 @@
 package main
 
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
 )
 
+// TCPStates counts a process tree's TCP sockets by state.
+type TCPStates struct {
+	Established int `json:"established"`
+	Listen      int `json:"listen"`
+	SynSent     int `json:"syn_sent,omitempty"`
+	SynRecv     int `json:"syn_recv,omitempty"`
+	FinWait     int `json:"fin_wait,omitempty"` // FIN_WAIT1 + FIN_WAIT2
+	CloseWait   int `json:"close_wait,omitempty"`
+	TimeWait    int `json:"time_wait,omitempty"`
+	Closing     int `json:"closing,omitempty"` // CLOSING + LAST_ACK
+}
+
+func (s *TCPStates) add(status string) {
+	switch status {
+	case "ESTABLISHED":
+		s.Established++
+	case "LISTEN":
+		s.Listen++
+	case "SYN_SENT":
+		s.SynSent++
+	case "SYN_RECV":
+		s.SynRecv++
+	case "FIN_WAIT1", "FIN_WAIT2":
+		s.FinWait++
+	case "CLOSE_WAIT":
+		s.CloseWait++
+	case "TIME_WAIT":
+		s.TimeWait++
+	case "CLOSING", "LAST_ACK":
+		s.Closing++
+	}
+}
+
+// MetricPoint is one sample of a process tree. Rates are per second over
+// the interval since the previous sample.
 type MetricPoint struct {
-	TimestampMS int64   `json:"timestamp_ms"`
-	CPU         float64 `json:"cpu"`
-	MemMB       float64 `json:"mem_mb"`
+	TimestampMS   int64     `json:"timestamp_ms"`
+	CPU           float64   `json:"cpu"`
+	MemMB         float64   `json:"mem_mb"`
+	Threads       int32     `json:"threads"`
+	ReadBps       float64   `json:"read_bps"`
+	WriteBps      float64   `json:"write_bps"`
+	FDs           int32     `json:"fds"` // open file descriptors, or handles on Windows
+	TCP           TCPStates `json:"tcp"`
+	ListenPorts   []uint32  `json:"listen_ports"`
+	CtxSwitchesPS float64   `json:"ctx_switches_ps"`
+	MinorFaultsPS float64   `json:"minor_faults_ps"`
+	MajorFaultsPS float64   `json:"major_faults_ps"`
+}
+
+// metricFields maps the names accepted by ?fields= to point accessors.
+var metricFields = map[string]func(p *MetricPoint) any{
+	"cpu":             func(p *MetricPoint) any { return p.CPU },
+	"mem_mb":          func(p *MetricPoint) any { return p.MemMB },
+	"threads":         func(p *MetricPoint) any { return p.Threads },
+	"read_bps":        func(p *MetricPoint) any { return p.ReadBps },
+	"write_bps":       func(p *MetricPoint) any { return p.WriteBps },
+	"fds":             func(p *MetricPoint) any { return p.FDs },
+	"tcp":             func(p *MetricPoint) any { return p.TCP },
+	"listen_ports":    func(p *MetricPoint) any { return p.ListenPorts },
+	"ctx_switches_ps": func(p *MetricPoint) any { return p.CtxSwitchesPS },
+	"minor_faults_ps": func(p *MetricPoint) any { return p.MinorFaultsPS },
+	"major_faults_ps": func(p *MetricPoint) any { return p.MajorFaultsPS },
+}
+
+// parseMetricFields validates a comma-separated ?fields= value. An empty
+// value selects everything and returns nil.
+func parseMetricFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	for _, f := range fields {
+		if _, ok := metricFields[f]; !ok {
+			known := make([]string, 0, len(metricFields))
+			for name := range metricFields {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown metric field %q (valid: %s)", f, strings.Join(known, ", "))
+		}
+	}
+	return fields, nil
+}
+
+// selectMetricFields projects points onto the chosen fields.
+func selectMetricFields(points []MetricPoint, fields []string) []map[string]any {
+	out := make([]map[string]any, len(points))
+	for i := range points {
+		m := make(map[string]any, len(fields)+1)
+		m["timestamp_ms"] = points[i].TimestampMS
+		for _, f := range fields {
+			m[f] = metricFields[f](&points[i])
+		}
+		out[i] = m
+	}
+	return out
 }
 
 type MetricsRingBuffer struct {
@@ -18,8 +117,9 @@ type MetricsRingBuffer struct {
 	mu     sync.Mutex
 }
 
-// Push adds a new metric point to the ring buffer
-func (mrb *MetricsRingBuffer) Push(cpu, memMB float64) {
+// Push adds a new metric point to the ring buffer, stamping it with the
+// current time
+func (mrb *MetricsRingBuffer) Push(p MetricPoint) {
 	mrb.mu.Lock()
 	defer mrb.mu.Unlock()
 
@@ -30,11 +130,8 @@ func (mrb *MetricsRingBuffer) Push(cpu, memMB float64) {
 	}
 
 	idx := (mrb.head + mrb.count - 1) % len(mrb.points)
-	mrb.points[idx] = MetricPoint{
-		TimestampMS: time.Now().UnixMilli(),
-		CPU:         cpu,
-		MemMB:       memMB,
-	}
+	p.TimestampMS = time.Now().UnixMilli()
+	mrb.points[idx] = p
 }
 
 // Last returns the last n metric points in chronological order
@@ -53,3 +150,138 @@ func (mrb *MetricsRingBuffer) Last(n int) []MetricPoint {
 	}
 	return result
 }
+
+// ── Sampling ─────────────────────────────────────────────────────────────────
+
+// procCounters are the cumulative per-process counters rates are derived from.
+type procCounters struct {
+	readBytes, writeBytes uint64
+	ctxSwitches           int64
+	minorFaults           uint64
+	majorFaults           uint64
+}
+
+// treeSampler keeps the previous counters of every process in one tree.
+type treeSampler struct {
+	prev     map[int32]procCounters
+	lastTime time.Time
+}
+
+// hostSnapshot holds host-wide data gathered at most once per monitor tick.
+type hostSnapshot struct {
+	children    map[int32][]int32
+	conns       map[int32][]net.ConnectionStat
+	connsLoaded bool
+}
+
+func (hs *hostSnapshot) childrenOf() map[int32][]int32 {
+	if hs.children == nil {
+		hs.children = childrenMap()
+	}
+	return hs.children
+}
+
+// connsByPID lists TCP sockets grouped by owning process. Enumerating
+// sockets is costly, so it is done once per tick for all processes.
+func (hs *hostSnapshot) connsByPID() map[int32][]net.ConnectionStat {
+	if !hs.connsLoaded {
+		hs.connsLoaded = true
+		hs.conns = make(map[int32][]net.ConnectionStat)
+		if conns, err := net.Connections("tcp"); err == nil {
+			for _, c := range conns {
+				hs.conns[c.Pid] = append(hs.conns[c.Pid], c)
+			}
+		}
+	}
+	return hs.conns
+}
+
+// sample measures pid and its descendants. A process seen for the first
+// time contributes nothing to rates until the next sample. count is 0 when
+// the root could not be read.
+func (ts *treeSampler) sample(hs *hostSnapshot, pid int32) (p MetricPoint, rss uint64, count int) {
+	now := time.Now()
+	elapsed := now.Sub(ts.lastTime).Seconds()
+	if ts.lastTime.IsZero() {
+		elapsed = 0
+	}
+	current := make(map[int32]procCounters)
+	var delta procCounters
+	ports := make(map[uint32]bool)
+	conns := hs.connsByPID()
+
+	for _, member := range append([]int32{pid}, descendantPIDs(hs.childrenOf(), pid)...) {
+		proc, err := process.NewProcess(member)
+		if err != nil {
+			continue
+		}
+		count++
+
+		cpu, _ := proc.CPUPercent()
+		p.CPU += cpu
+		if mem, err := proc.MemoryInfo(); err == nil && mem != nil {
+			rss += mem.RSS
+		}
+		threads, _ := proc.NumThreads()
+		p.Threads += threads
+		fds, _ := proc.NumFDs()
+		p.FDs += fds
+
+		var c procCounters
+		if io, err := proc.IOCounters(); err == nil && io != nil {
+			c.readBytes, c.writeBytes = io.ReadBytes, io.WriteBytes
+		}
+		if cs, err := proc.NumCtxSwitches(); err == nil && cs != nil {
+			c.ctxSwitches = cs.Voluntary + cs.Involuntary
+		}
+		if pf, err := proc.PageFaults(); err == nil && pf != nil {
+			c.minorFaults, c.majorFaults = pf.MinorFaults, pf.MajorFaults
+		}
+		current[member] = c
+		if prev, ok := ts.prev[member]; ok {
+			delta.readBytes += sub(c.readBytes, prev.readBytes)
+			delta.writeBytes += sub(c.writeBytes, prev.writeBytes)
+			delta.minorFaults += sub(c.minorFaults, prev.minorFaults)
+			delta.majorFaults += sub(c.majorFaults, prev.majorFaults)
+			if c.ctxSwitches > prev.ctxSwitches {
+				delta.ctxSwitches += c.ctxSwitches - prev.ctxSwitches
+			}
+		}
+
+		for _, conn := range conns[member] {
+			p.TCP.add(conn.Status)
+			if conn.Status == "LISTEN" {
+				ports[conn.Laddr.Port] = true
+			}
+		}
+	}
+
+	if elapsed > 0 {
+		p.ReadBps = float64(delta.readBytes) / elapsed
+		p.WriteBps = float64(delta.writeBytes) / elapsed
+		p.CtxSwitchesPS = float64(delta.ctxSwitches) / elapsed
+		p.MinorFaultsPS = float64(delta.minorFaults) / elapsed
+		p.MajorFaultsPS = float64(delta.majorFaults) / elapsed
+	}
+	p.ListenPorts = make([]uint32, 0, len(ports))
+	for port := range ports {
+		p.ListenPorts = append(p.ListenPorts, port)
+	}
+	sort.Slice(p.ListenPorts, func(i, j int) bool { return p.ListenPorts[i] < p.ListenPorts[j] })
+	p.MemMB = float64(rss) / 1024 / 1024
+
+	ts.prev, ts.lastTime = current, now
+	return p, rss, count
+}
+
+// sub returns a-b, or 0 if a counter went backwards.
+func sub(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
+
+func (ts *treeSampler) reset() {
+	ts.prev, ts.lastTime = nil, time.Time{}
+}
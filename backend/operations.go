@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operation actions
+const (
+	OpStart      = "start"
+	OpStop       = "stop"
+	OpRestart    = "restart"
+	OpStartAll   = "start_all"
+	OpStopAll    = "stop_all"
+	OpRestartAll = "restart_all"
+)
+
+// Operation and step states
+const (
+	OpPending   = "pending"
+	OpRunning   = "running"
+	OpSucceeded = "succeeded"
+	OpFailed    = "failed"
+	OpPartial   = "partial"
+	OpCancelled = "cancelled"
+)
+
+const maxRetainedOperations = 200
+
+// OperationStep is one process's part of an operation.
+type OperationStep struct {
+	ProcessID    string `json:"process_id"`
+	Action       string `json:"action"` // start, stop or restart
+	State        string `json:"state"`
+	Error        string `json:"error,omitempty"`
+	StartedAtMS  int64  `json:"started_at_ms,omitempty"`
+	FinishedAtMS int64  `json:"finished_at_ms,omitempty"`
+}
+
+// Operation tracks an asynchronous start/stop/restart request.
+type Operation struct {
+	ID           string          `json:"id"`
+	Action       string          `json:"action"`
+	State        string          `json:"state"`
+	User         string          `json:"user,omitempty"`
+	CreatedAtMS  int64           `json:"created_at_ms"`
+	FinishedAtMS int64           `json:"finished_at_ms,omitempty"`
+	Steps        []OperationStep `json:"steps"`
+
+	actor   actor
+	target  string         // audit target for group operations
+	changes []ConfigChange // auto-restart changes made by stop steps, for the audit log
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func (op *Operation) finished() bool {
+	return op.FinishedAtMS != 0
+}
+
+// OperationManager stores operations and publishes their progress. All
+// Operation fields are guarded by mu; readers get copies via snapshot.
+type OperationManager struct {
+	ops    map[string]*Operation
+	order  []string
+	mu     sync.Mutex
+	notify func(Operation)
+	redact func(string) string // masks secrets in step errors
+}
+
+func newOperationManager(notify func(Operation), redact func(string) string) *OperationManager {
+	return &OperationManager{
+		ops:    make(map[string]*Operation),
+		notify: notify,
+		redact: redact,
+	}
+}
+
+func newOperationID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "op-" + hex.EncodeToString(b)
+}
+
+// snapshotLocked copies op so it can be used outside the lock.
+func snapshotLocked(op *Operation) Operation {
+	s := *op
+	s.Steps = append([]OperationStep(nil), op.Steps...)
+	s.changes = nil
+	return s
+}
+
+// create registers a new pending operation. It fails if a pending or running
+// operation of any kind already covers one of the processes, so a
+// double-clicked restart doesn't restart twice and a stop isn't queued
+// behind a restart. Removals and scaling are not refused: they carry out a
+// config change that is already saved, so they wait for the processes
+// instead.
+func (om *OperationManager) create(a actor, action string, steps []OperationStep) (*Operation, error) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+
+	for _, id := range om.order {
+		existing := om.ops[id]
+		if existing.finished() || action == OpRemove || action == OpScale {
+			continue
+		}
+		for _, es := range existing.Steps {
+			for _, s := range steps {
+				if es.ProcessID == s.ProcessID && es.State != OpCancelled {
+					return nil, &opConflictError{ProcessID: s.ProcessID, OperationID: existing.ID}
+				}
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	op := &Operation{
+		ID:          newOperationID(),
+		Action:      action,
+		State:       OpPending,
+		User:        a.User.Name,
+		CreatedAtMS: time.Now().UnixMilli(),
+		Steps:       steps,
+		actor:       a,
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	om.ops[op.ID] = op
+	om.order = append(om.order, op.ID)
+	om.pruneLocked()
+	om.notify(snapshotLocked(op))
+	return op, nil
+}
+
+// pruneLocked drops the oldest finished operations beyond the retention limit.
+func (om *OperationManager) pruneLocked() {
+	excess := len(om.order) - maxRetainedOperations
+	if excess <= 0 {
+		return
+	}
+	kept := om.order[:0]
+	for _, id := range om.order {
+		if excess > 0 && om.ops[id].finished() {
+			delete(om.ops, id)
+			excess--
+			continue
+		}
+		kept = append(kept, id)
+	}
+	om.order = kept
+}
+
+// update applies fn to op under the lock and publishes the result.
+func (om *OperationManager) update(op *Operation, fn func(op *Operation)) {
+	om.mu.Lock()
+	fn(op)
+	s := snapshotLocked(op)
+	om.mu.Unlock()
+	om.notify(s)
+}
+
+func (om *OperationManager) beginStep(op *Operation, i int) {
+	om.update(op, func(op *Operation) {
+		op.State = OpRunning
+		op.Steps[i].State = OpRunning
+		op.Steps[i].StartedAtMS = time.Now().UnixMilli()
+	})
+}
+
+func (om *OperationManager) finishStep(op *Operation, i int, state string, err error) {
+	om.update(op, func(op *Operation) {
+		op.Steps[i].State = state
+		op.Steps[i].FinishedAtMS = time.Now().UnixMilli()
+		if err != nil {
+			op.Steps[i].Error = om.redact(err.Error())
+		}
+	})
+}
+
+// finish derives the overall state from the steps and wakes any waiters.
+func (om *OperationManager) finish(op *Operation) Operation {
+	var s Operation
+	om.update(op, func(op *Operation) {
+		succeeded, failed, cancelled := 0, 0, 0
+		for _, st := range op.Steps {
+			switch st.State {
+			case OpSucceeded:
+				succeeded++
+			case OpFailed:
+				failed++
+			case OpCancelled:
+				cancelled++
+			}
+		}
+		switch {
+		case cancelled > 0 && failed == 0:
+			op.State = OpCancelled
+		case failed > 0 && succeeded > 0:
+			op.State = OpPartial
+		case failed > 0 || cancelled > 0:
+			op.State = OpFailed
+		default:
+			op.State = OpSucceeded
+		}
+		op.FinishedAtMS = time.Now().UnixMilli()
+		s = snapshotLocked(op)
+	})
+	op.cancel()
+	close(op.done)
+	return s
+}
+
+func (om *OperationManager) get(id string) (*Operation, bool) {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	op, ok := om.ops[id]
+	return op, ok
+}
+
+func (om *OperationManager) snapshot(op *Operation) Operation {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	return snapshotLocked(op)
+}
+
+// list returns copies of all retained operations, newest first.
+func (om *OperationManager) list() []Operation {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	result := make([]Operation, 0, len(om.order))
+	for _, id := range om.order {
+		result = append(result, snapshotLocked(om.ops[id]))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAtMS > result[j].CreatedAtMS })
+	return result
+}
+
+type opConflictError struct {
+	ProcessID   string
+	OperationID string
+}
+
+func (e *opConflictError) Error() string {
+	return fmt.Sprintf("operation %s is already in progress for %s", e.OperationID, e.ProcessID)
+}
+
+// visibleOperation filters an operation's steps to the processes the user may view,
+// reporting false if none remain.
+func (pm *ProcessManager) visibleOperation(u *User, op Operation) (Operation, bool) {
+	if u.allowAll {
+		return op, true
+	}
+	steps := make([]OperationStep, 0, len(op.Steps))
+	pm.mu.RLock()
+	for _, s := range op.Steps {
+		category := ""
+		if mp, ok := pm.processes[s.ProcessID]; ok {
+			category = mp.Config().Category
+		}
+		if u.can(PermView, s.ProcessID, category) {
+			steps = append(steps, s)
+		}
+	}
+	pm.mu.RUnlock()
+	op.Steps = steps
+	return op, len(steps) > 0
+}
+
+// publishOperation pushes operation progress to WebSocket clients. Legacy
+// clients only ever get status arrays, so they are not sent these.
+func (pm *ProcessManager) publishOperation(op Operation) {
+	pm.hub.publish(wsMessage{topic: TopicOperations, render: func(u *User) any {
+		if v, ok := pm.visibleOperation(u, op); ok {
+			return v
+		}
+		return nil
+	}})
+}
+
+// ── Execution ────────────────────────────────────────────────────────────────
+
+// submitOperation queues action for targets (already in execution order)
+// and runs it in the background.
+func (pm *ProcessManager) submitOperation(a actor, action string, targets []*ManagedProcess) (*Operation, error) {
+	stepAction := map[string]string{
+		OpStart: OpStart, OpStartAll: OpStart,
+		OpStop: OpStop, OpStopAll: OpStop,
+		OpRestart: OpRestart, OpRestartAll: OpRestart,
+		OpGroupStart: OpStart, OpGroupStop: OpStop,
+	}[action]
+
+	steps := make([]OperationStep, len(targets))
+	for i, mp := range targets {
+		steps[i] = OperationStep{ProcessID: mp.Config().ID, Action: stepAction, State: OpPending}
+	}
+	op, err := pm.ops.create(a, action, steps)
+	if err != nil {
+		return nil, err
+	}
+
+	go pm.runOperation(op, targets)
+	return op, nil
+}
+
+func (pm *ProcessManager) runOperation(op *Operation, targets []*ManagedProcess) {
+	if op.Action == OpRestartAll {
+		pm.runRestartAll(op, targets)
+	} else {
+		for i, mp := range targets {
+			pm.runStep(op, i, mp)
+		}
+	}
+
+	s := pm.ops.finish(op)
+	pm.auditOperation(op, s)
+}
+
+// runStep performs one step once the process is free. Cancellation is
+// honoured while waiting; a step that has begun always runs to completion.
+func (pm *ProcessManager) runStep(op *Operation, i int, mp *ManagedProcess) {
+	if err := mp.beginOp(op.ctx); err != nil {
+		pm.ops.finishStep(op, i, OpCancelled, nil)
+		return
+	}
+	defer mp.endOp()
+
+	pm.ops.beginStep(op, i)
+	var err error
+	switch op.Steps[i].Action {
+	case OpStart:
+		err = pm.startProcess(mp, true)
+	case OpStop:
+		if err = pm.stopProcess(mp); err == nil {
+			// A manual stop disables auto-restart so the process stays down.
+			if before, changed := pm.setAutoRestart(op.actor, mp, false); changed {
+				pm.ops.update(op, func(op *Operation) {
+					op.changes = append(op.changes, autoRestartChange(mp.Config().ID, before, false))
+				})
+			}
+		}
+	case OpRestart:
+		_, err = pm.restartProcess(mp)
+	case OpRemove:
+		err = pm.removeProcess(mp)
+	}
+
+	state := OpSucceeded
+	if err != nil {
+		state = OpFailed
+	}
+	pm.ops.finishStep(op, i, state, err)
+}
+
+// runRestartAll claims every target, stops them in reverse order, then
+// starts them again in config order, recording one restarted event each.
+func (pm *ProcessManager) runRestartAll(op *Operation, targets []*ManagedProcess) {
+	locked := make([]bool, len(targets))
+	defer func() {
+		for i, mp := range targets {
+			if locked[i] {
+				mp.endOp()
+			}
+		}
+	}()
+	for i, mp := range targets {
+		if err := mp.beginOp(op.ctx); err != nil {
+			break
+		}
+		locked[i] = true
+	}
+	for i := range targets {
+		if !locked[i] {
+			pm.ops.finishStep(op, i, OpCancelled, nil)
+		}
+	}
+
+	exited := make([]chan struct{}, len(targets))
+	begin := make([]time.Time, len(targets))
+	stopped := make([]bool, len(targets))
+	for i := len(targets) - 1; i >= 0; i-- {
+		if !locked[i] {
+			continue
+		}
+		if op.ctx.Err() != nil {
+			pm.ops.finishStep(op, i, OpCancelled, nil)
+			continue
+		}
+		mp := targets[i]
+		pm.ops.beginStep(op, i)
+		mp.restarting.Store(true)
+		mp.mu.Lock()
+		exited[i] = mp.exited
+		mp.mu.Unlock()
+		begin[i] = time.Now()
+
+		err := pm.stopProcess(mp)
+		if err == nil {
+			err = pm.waitStopped(mp, exited[i])
+		}
+		if err != nil {
+			mp.restarting.Store(false)
+			pm.ops.finishStep(op, i, OpFailed, fmt.Errorf("stop failed: %w", err))
+			continue
+		}
+		stopped[i] = true
+	}
+
+	for i, mp := range targets {
+		if !stopped[i] {
+			continue
+		}
+		if op.ctx.Err() != nil {
+			mp.restarting.Store(false)
+			pm.recordEvent(mp, EventStopped) // folded into the restart until now
+			pm.ops.finishStep(op, i, OpCancelled, errors.New("cancelled after stopping; left stopped"))
+			continue
+		}
+		if err := pm.startProcess(mp, true); err != nil {
+			err = fmt.Errorf("start failed: %w", err)
+			pm.recordRestartFailed(mp, err)
+			pm.ops.finishStep(op, i, OpFailed, err)
+			continue
+		}
+		mp.restarting.Store(false)
+		pm.events.RecordTimed(mp.Config().ID, mp.Config().Name, EventRestarted, time.Since(begin[i]))
+		pm.ops.finishStep(op, i, OpSucceeded, nil)
+	}
+}
+
+var opAuditActions = map[string]string{
+	OpStart: AuditStart, OpStop: AuditStop, OpRestart: AuditRestart,
+	OpStartAll: AuditStartAll, OpStopAll: AuditStopAll, OpRestartAll: AuditRestartAll,
+	OpGroupStart: AuditGroupStart, OpGroupStop: AuditGroupStop, OpScale: AuditScale,
+	OpRemove: AuditRemove,
+}
+
+// auditOperation records a finished operation on behalf of its requester.
+func (pm *ProcessManager) auditOperation(op *Operation, s Operation) {
+	entry := AuditEntry{
+		Action: opAuditActions[s.Action],
+		Params: map[string]any{"operation_id": s.ID},
+	}
+	if op.target != "" {
+		entry.Target = op.target
+	} else if len(s.Steps) == 1 {
+		entry.Target = s.Steps[0].ProcessID
+	}
+	errors := make(map[string]string)
+	for _, st := range s.Steps {
+		if st.Error != "" {
+			errors[st.ProcessID] = st.Error
+		}
+	}
+	if len(errors) > 0 {
+		entry.Params["errors"] = errors
+	}
+	switch s.State {
+	case OpSucceeded:
+		entry.Result = "ok"
+	case OpPartial:
+		entry.Result = "partial"
+	case OpCancelled:
+		entry.Result = "cancelled"
+	default:
+		entry.Result = "error"
+	}
+
+	pm.ops.mu.Lock()
+	entry.Changes = op.changes
+	pm.ops.mu.Unlock()
+
+	pm.recordAuditAs(op.actor, entry, nil)
+}
+
+// ── HTTP ─────────────────────────────────────────────────────────────────────
+
+// respondOperation replies 202 with the queued operation, or with ?wait=true
+// blocks until it finishes and maps its outcome to a status code.
+func (pm *ProcessManager) respondOperation(w http.ResponseWriter, r *http.Request, op *Operation) {
+	if r.URL.Query().Get("wait") != "true" {
+		writeJSON(w, http.StatusAccepted, pm.ops.snapshot(op))
+		return
+	}
+
+	select {
+	case <-op.done:
+	case <-r.Context().Done():
+		return
+	}
+	s := pm.ops.snapshot(op)
+	status := http.StatusOK
+	switch s.State {
+	case OpPartial:
+		status = http.StatusMultiStatus
+	case OpFailed:
+		status = http.StatusInternalServerError
+	case OpCancelled:
+		status = http.StatusConflict
+	}
+	writeJSON(w, status, s)
+}
+
+// submitAndRespond is the common tail of the lifecycle handlers.
+func (pm *ProcessManager) submitAndRespond(w http.ResponseWriter, r *http.Request, action string, targets []*ManagedProcess) {
+	op, err := pm.submitOperation(requestActor(r), action, targets)
+	if err != nil {
+		if conflict, ok := err.(*opConflictError); ok {
+			writeJSON(w, http.StatusConflict, map[string]string{
+				"error":        conflict.Error(),
+				"operation_id": conflict.OperationID,
+			})
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pm.respondOperation(w, r, op)
+}
+
+func (pm *ProcessManager) handleListOperations(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	result := []Operation{}
+	for _, op := range pm.ops.list() {
+		if v, ok := pm.visibleOperation(user, op); ok {
+			result = append(result, v)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (pm *ProcessManager) handleGetOperation(w http.ResponseWriter, r *http.Request) {
+	op, ok := pm.ops.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "operation not found")
+		return
+	}
+	v, ok := pm.visibleOperation(requestUser(r), pm.ops.snapshot(op))
+	if !ok {
+		writeError(w, http.StatusNotFound, "operation not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// handleCancelOperation cancels steps that have not started yet. Steps
+// already running complete normally.
+func (pm *ProcessManager) handleCancelOperation(w http.ResponseWriter, r *http.Request) {
+	op, ok := pm.ops.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "operation not found")
+		return
+	}
+
+	user := requestUser(r)
+	s := pm.ops.snapshot(op)
+	pm.mu.RLock()
+	for _, st := range s.Steps {
+		mp, exists := pm.processes[st.ProcessID]
+		if exists && !user.canProcess(PermControl, mp.Config()) {
+			pm.mu.RUnlock()
+			writeError(w, http.StatusForbidden, "permission denied: "+PermControl)
+			return
+		}
+	}
+	pm.mu.RUnlock()
+
+	op.cancel()
+	writeJSON(w, http.StatusOK, pm.ops.snapshot(op))
+}
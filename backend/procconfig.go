@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ── Entity tags ──────────────────────────────────────────────────────────────
+
+// configETagLocked returns the entity tag of the config files as stored on
+// disk. Every writer changes a file, so any write changes the tag. The
+// caller must hold pm.mu.
+func (pm *ProcessManager) configETagLocked() (string, error) {
+	sum, err := configStateSum(pm.configPath)
+	if err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(sum[:8]) + `"`, nil
+}
+
+// checkIfMatch enforces an If-Match header against the current tag, writing
+// 412 when it is stale. Requests without the header are not checked.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	w.Header().Set("ETag", etag)
+	writeError(w, http.StatusPreconditionFailed, "config changed since it was read; reload and retry")
+	return false
+}
+
+// ── Validation ───────────────────────────────────────────────────────────────
+
+// processIDPattern restricts process IDs, however the config is loaded; IDs
+// name log files and cgroups and appear in URLs.
+var processIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,63}$`)
+
+const processIDRule = "must be 1-64 letters, digits, '.', '_' or '-', starting with a letter or digit"
+
+// validProcessID reports whether id is a valid definition ID, or the ID of
+// a replica of one.
+func validProcessID(id string) bool {
+	base, n, replica := strings.Cut(id, "#")
+	if !processIDPattern.MatchString(base) {
+		return false
+	}
+	if !replica {
+		return true
+	}
+	num, err := strconv.Atoi(n)
+	return err == nil && num >= 1 && replicaID(base, num) == id
+}
+
+// processConfigError reports invalid fields of a process definition, keyed
+// by JSON field name.
+type processConfigError struct {
+	Fields map[string]string
+}
+
+func (e *processConfigError) Error() string {
+	keys := make([]string, 0, len(e.Fields))
+	for k := range e.Fields {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + ": " + e.Fields[k]
+	}
+	return strings.Join(parts, "; ")
+}
+
+// validateProcessFields checks one definition field by field. Checks that
+// span the whole config (duplicate IDs and ports, secret references) are
+// left to validateConfig.
+func validateProcessFields(pc *ProcessConfig) error {
+	fields := make(map[string]string)
+	if !processIDPattern.MatchString(pc.ID) {
+		fields["id"] = processIDRule
+	}
+	if strings.TrimSpace(pc.Name) == "" {
+		fields["name"] = "is required"
+	}
+	if pc.IsService {
+		if pc.ServiceName == "" {
+			fields["service_name"] = "is required for services"
+		} else if containsDangerousChars(pc.ServiceName) {
+			fields["service_name"] = "contains invalid characters"
+		}
+	} else if pc.Executable == "" {
+		fields["executable"] = "is required"
+	}
+	if containsDangerousChars(pc.Executable) {
+		fields["executable"] = "contains invalid characters"
+	}
+	if containsDangerousChars(pc.WorkingDir) {
+		fields["working_dir"] = "contains invalid characters"
+	}
+	for i, arg := range pc.Args {
+		if containsDangerousChars(arg) {
+			fields[fmt.Sprintf("args[%d]", i)] = "contains invalid characters"
+		}
+	}
+	for name, v := range map[string]int{
+		"shutdown_delay":   pc.ShutdownDelay,
+		"log_max_size_mb":  pc.LogMaxSizeMB,
+		"log_max_backups":  pc.LogMaxBackups,
+		"log_max_age_days": pc.LogMaxAgeDays,
+	} {
+		if v < 0 {
+			fields[name] = "must be >= 0"
+		}
+	}
+	if pc.Replicas != nil && (*pc.Replicas < 0 || *pc.Replicas > maxReplicas) {
+		fields["replicas"] = fmt.Sprintf("must be between 0 and %d", maxReplicas)
+	}
+	if err := validateProcessEnv(pc); err != nil {
+		fields["env"] = err.Error()
+	}
+	if err := pc.Limits.validate(pc.ID); err != nil {
+		fields["limits"] = err.Error()
+	}
+	if err := validateIdentity(pc); err != nil {
+		fields["user"] = err.Error()
+	}
+	if len(fields) > 0 {
+		return &processConfigError{Fields: fields}
+	}
+	return nil
+}
+
+// decodeProcessConfig decodes a definition strictly, so misspelt fields are
+// reported instead of silently ignored.
+func decodeProcessConfig(data []byte) (ProcessConfig, error) {
+	var pc ProcessConfig
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&pc); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			return pc, &processConfigError{Fields: map[string]string{typeErr.Field: "wrong type (want " + typeErr.Type.String() + ")"}}
+		}
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return pc, &processConfigError{Fields: map[string]string{strings.Trim(field, `"`): "unknown field"}}
+		}
+		return pc, errors.New("invalid JSON")
+	}
+	return pc, nil
+}
+
+// writeConfigError answers a rejected definition, with per-field messages
+// when there are any.
+func writeConfigError(w http.ResponseWriter, err error) {
+	var fieldErr *processConfigError
+	if errors.As(err, &fieldErr) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid process config", "fields": fieldErr.Fields})
+		return
+	}
+	if errors.Is(err, errConfigConflict) {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if errors.Is(err, errConfigLocked) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386) to a decoded document.
+func mergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+// ── Reconciling ──────────────────────────────────────────────────────────────
+
+// needsRestart reports whether a running process must be restarted to pick
+// up a config change. Fields read while it runs or at stop time don't count.
+func needsRestart(before, after ProcessConfig) bool {
+	for _, pc := range []*ProcessConfig{&before, &after} {
+		pc.Name, pc.Category, pc.AutoRestart, pc.ShutdownDelay = "", "", false, 0
+		pc.Source = ""
+	}
+	return !reflect.DeepEqual(before, after)
+}
+
+// reconcileLocked brings the managed processes of definition id in line
+// with pm.cfg. New instances are added stopped. Existing ones take the new
+// settings; those running on old launch settings are listed in restart.
+// Instances the definition no longer has are returned for removal. The
+// caller must hold pm.mu.
+func (pm *ProcessManager) reconcileLocked(id string) (added, removed []*ManagedProcess, restart []string) {
+	current := make(map[string]*ManagedProcess)
+	for pid, mp := range pm.processes {
+		if replicaBase(pid) == id {
+			current[pid] = mp
+		}
+	}
+
+	if idx := pm.definitionIndexLocked(id); idx >= 0 {
+		for _, pc := range expandProcess(pm.cfg.Processes[idx]) {
+			mp, ok := current[pc.ID]
+			if !ok {
+				mp = newManagedProcess(pc)
+				pm.processes[pc.ID] = mp
+				pm.insertOrderLocked(idx, mp)
+				added = append(added, mp)
+				continue
+			}
+			delete(current, pc.ID)
+			mp.mu.Lock()
+			if mp.State == StateRunning && needsRestart(*mp.Config(), pc) {
+				restart = append(restart, pc.ID)
+			}
+			mp.config.Store(&pc)
+			mp.mu.Unlock()
+		}
+	}
+
+	for _, pid := range pm.order {
+		if mp, ok := current[pid]; ok {
+			removed = append(removed, mp)
+		}
+	}
+	return added, removed, restart
+}
+
+// changedDefinitions returns the IDs of definitions that were added, changed
+// or deleted between two configs.
+func changedDefinitions(before, after *Config) []string {
+	old := make(map[string]ProcessConfig, len(before.Processes))
+	for _, pc := range before.Processes {
+		old[pc.ID] = pc
+	}
+	var ids []string
+	for _, pc := range after.Processes {
+		if prev, ok := old[pc.ID]; !ok || !reflect.DeepEqual(prev, pc) {
+			ids = append(ids, pc.ID)
+		}
+		delete(old, pc.ID)
+	}
+	for _, pc := range before.Processes {
+		if _, ok := old[pc.ID]; ok {
+			ids = append(ids, pc.ID)
+		}
+	}
+	return ids
+}
+
+// applyConfigLocked validates a new config, saves it and reconciles it into
+// the process table. The caller must hold pm.mu; it is held throughout so an
+// If-Match check and the write are atomic.
+func (pm *ProcessManager) applyConfigLocked(a actor, cfg *Config, reason string, entry AuditEntry) (restart []string, op *Operation, err error) {
+	if err := validateConfig(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := pm.secrets.checkSecretRefs(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := pm.assignSourcesLocked(cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := pm.writeConfigLocked(a, cfg, reason); err != nil {
+		if errors.Is(err, errConfigConflict) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("failed to write config: %w", err)
+	}
+	return pm.reconcileConfigLocked(a, cfg, entry)
+}
+
+// assignSourcesLocked settles which file each process of cfg is saved to. A
+// process without a source stays in the file it is defined in now, and a
+// new one goes to the main config. Naming the main config moves a process
+// there. The caller must hold pm.mu.
+func (pm *ProcessManager) assignSourcesLocked(cfg *Config) error {
+	current := make(map[string]string, len(pm.cfg.Processes))
+	for _, pc := range pm.cfg.Processes {
+		current[pc.ID] = pc.Source
+	}
+	known := make(map[string]bool, len(pm.configFiles))
+	for _, f := range pm.configFiles[1:] {
+		known[f.name] = true
+	}
+	for i := range cfg.Processes {
+		pc := &cfg.Processes[i]
+		switch {
+		case pc.Source == "":
+			pc.Source = current[pc.ID]
+		case pc.Source == pm.configFiles[0].name:
+			pc.Source = ""
+		case !known[pc.Source]:
+			return &processConfigError{Fields: map[string]string{"source": pc.ID + ": " + pc.Source + " is not a config file"}}
+		}
+	}
+	return nil
+}
+
+// reconcileConfigLocked makes cfg the live config and reconciles the
+// definitions that changed into the process table. Instances that have to go
+// are stopped and dropped by an operation, returned if there is one. entry
+// is the audit entry to record; its changes are filled in. The caller must
+// hold pm.mu.
+func (pm *ProcessManager) reconcileConfigLocked(a actor, cfg *Config, entry AuditEntry) (restart []string, op *Operation, err error) {
+	ids := changedDefinitions(pm.cfg, cfg)
+	entry.Changes = diffConfig(pm.cfg, cfg)
+	pm.cfg = cfg
+
+	var removed []*ManagedProcess
+	var groups []string // definitions with instances to remove
+	for _, id := range ids {
+		added, gone, needRestart := pm.reconcileLocked(id)
+		for _, mp := range added {
+			pm.events.Record(mp.Config().ID, mp.Config().Name, EventAdded)
+		}
+		restart = append(restart, needRestart...)
+		if len(gone) > 0 {
+			removed = append(removed, gone...)
+			groups = append(groups, id)
+		}
+	}
+	pm.recordAuditAs(a, entry, nil)
+
+	if len(removed) == 0 {
+		return restart, nil, nil
+	}
+	steps := make([]OperationStep, len(removed))
+	for i, mp := range removed {
+		steps[i] = OperationStep{ProcessID: mp.Config().ID, Action: OpRemove, State: OpPending}
+	}
+	// Removals are serialised per definition by pm.scaling, so create
+	// cannot conflict with another one.
+	op, err = pm.ops.create(a, OpRemove, steps)
+	if err != nil {
+		return restart, nil, err
+	}
+	pm.ops.mu.Lock()
+	op.target = entry.Target
+	pm.ops.mu.Unlock()
+	for _, id := range groups {
+		pm.scaling[id] = true
+	}
+	go func() {
+		pm.runOperation(op, removed)
+		pm.mu.Lock()
+		for _, id := range groups {
+			delete(pm.scaling, id)
+		}
+		pm.mu.Unlock()
+	}()
+	return restart, op, nil
+}
+
+// cloneConfig copies cfg with its own process list, so a candidate can be
+// edited and validated without touching the live config.
+func cloneConfig(cfg *Config) *Config {
+	clone := *cfg
+	clone.Processes = slices.Clone(cfg.Processes)
+	return &clone
+}
+
+// ── Handlers ─────────────────────────────────────────────────────────────────
+
+// definitionResponse is returned by the definition endpoints after a write.
+type definitionResponse struct {
+	Process         *ProcessConfig `json:"process,omitempty"`
+	RestartRequired []string       `json:"restart_required,omitempty"` // running instances still on old settings
+	Operation       *Operation     `json:"operation,omitempty"`        // removal of instances that went away
+}
+
+func (pm *ProcessManager) writeDefinition(w http.ResponseWriter, status int, etag string, resp definitionResponse) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	writeJSON(w, status, resp)
+}
+
+// handleListDefinitions returns the process definitions the caller may read,
+// as stored in config.json. Served by GET /api/processes?view=config.
+func (pm *ProcessManager) handleListDefinitions(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+	pm.mu.RLock()
+	etag, _ := pm.configETagLocked()
+	defs := make([]ProcessConfig, 0, len(pm.cfg.Processes))
+	for _, pc := range pm.cfg.Processes {
+		if user.canProcess(PermConfigRead, &pc) {
+			defs = append(defs, pc)
+		}
+	}
+	pm.mu.RUnlock()
+
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, defs)
+}
+
+// lookupDefinition finds the definition named by the {id} path value and
+// checks perm on it, writing the error response otherwise. The caller must
+// hold pm.mu.
+func (pm *ProcessManager) lookupDefinitionLocked(w http.ResponseWriter, r *http.Request, perm string) (int, bool) {
+	id := r.PathValue("id")
+	idx := pm.definitionIndexLocked(id)
+	if idx < 0 {
+		if base := replicaBase(id); base != id && pm.definitionIndexLocked(base) >= 0 {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("%s is a replica; its config belongs to %s", id, base))
+		} else {
+			writeError(w, http.StatusNotFound, "process not found")
+		}
+		return -1, false
+	}
+	if !authorize(w, r, perm, &pm.cfg.Processes[idx]) {
+		return -1, false
+	}
+	return idx, true
+}
+
+func (pm *ProcessManager) handleGetProcessConfig(w http.ResponseWriter, r *http.Request) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	idx, ok := pm.lookupDefinitionLocked(w, r, PermConfigRead)
+	if !ok {
+		return
+	}
+	etag, _ := pm.configETagLocked()
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, pm.cfg.Processes[idx])
+}
+
+// handleCreateProcess adds a process definition. Its instances start out
+// stopped.
+func (pm *ProcessManager) handleCreateProcess(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	var body bytes.Buffer
+	if _, err := body.ReadFrom(r.Body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	pc, err := decodeProcessConfig(body.Bytes())
+	if err == nil {
+		err = validateProcessFields(&pc)
+	}
+	if err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	if !authorize(w, r, PermConfigWrite, &pc) {
+		return
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	etag, err := pm.configETagLocked()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read config")
+		return
+	}
+	if !checkIfMatch(w, r, etag) {
+		return
+	}
+	if pm.definitionIndexLocked(pc.ID) >= 0 || pm.processes[pc.ID] != nil {
+		writeError(w, http.StatusConflict, "process already exists: "+pc.ID)
+		return
+	}
+	if pm.scaling[pc.ID] {
+		writeError(w, http.StatusConflict, "changes to "+pc.ID+" are still being applied")
+		return
+	}
+
+	cfg := cloneConfig(pm.cfg)
+	cfg.Processes = append(cfg.Processes, pc)
+	entry := AuditEntry{Action: AuditConfigWrite, Target: pc.ID}
+	if _, _, err := pm.applyConfigLocked(requestActor(r), cfg, configReason(r, "create "+pc.ID), entry); err != nil {
+		pm.recordAudit(r, entry, err)
+		writeConfigError(w, err)
+		return
+	}
+	etag, _ = pm.configETagLocked()
+	w.Header().Set("Location", "/api/processes/"+pc.ID+"/config")
+	pm.writeDefinition(w, http.StatusCreated, etag, definitionResponse{Process: &pc})
+}
+
+// handlePatchProcessConfig applies a JSON merge patch to one definition.
+func (pm *ProcessManager) handlePatchProcessConfig(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, 1024*1024)
+	var patch map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		writeError(w, http.StatusBadRequest, "body must be a JSON merge patch object")
+		return
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	idx, ok := pm.lookupDefinitionLocked(w, r, PermConfigWrite)
+	if !ok {
+		return
+	}
+	etag, err := pm.configETagLocked()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read config")
+		return
+	}
+	if !checkIfMatch(w, r, etag) {
+		return
+	}
+	old := pm.cfg.Processes[idx]
+	if pm.scaling[old.ID] {
+		writeError(w, http.StatusConflict, "changes to "+old.ID+" are still being applied")
+		return
+	}
+
+	var doc any
+	data, _ := json.Marshal(old)
+	json.Unmarshal(data, &doc)
+	data, _ = json.Marshal(mergePatch(doc, patch))
+	pc, err := decodeProcessConfig(data)
+	if err == nil && pc.ID != old.ID {
+		err = &processConfigError{Fields: map[string]string{"id": "cannot be changed"}}
+	}
+	if err == nil {
+		err = validateProcessFields(&pc)
+	}
+	if err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	// A category change must not move the process out of the caller's reach
+	if !authorize(w, r, PermConfigWrite, &pc) {
+		return
+	}
+
+	cfg := cloneConfig(pm.cfg)
+	cfg.Processes[idx] = pc
+	entry := AuditEntry{Action: AuditConfigWrite, Target: pc.ID}
+	restart, op, err := pm.applyConfigLocked(requestActor(r), cfg, configReason(r, "update "+pc.ID), entry)
+	if err != nil {
+		pm.recordAudit(r, entry, err)
+		writeConfigError(w, err)
+		return
+	}
+	resp := definitionResponse{Process: &pc, RestartRequired: restart}
+	if op != nil {
+		s := pm.ops.snapshot(op)
+		resp.Operation = &s
+	}
+	etag, _ = pm.configETagLocked()
+	pm.writeDefinition(w, http.StatusOK, etag, resp)
+}
+
+// handleDeleteProcessConfig removes a definition. Its instances are stopped
+// and dropped by an operation; their log files are kept.
+func (pm *ProcessManager) handleDeleteProcessConfig(w http.ResponseWriter, r *http.Request) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	idx, ok := pm.lookupDefinitionLocked(w, r, PermConfigWrite)
+	if !ok {
+		return
+	}
+	etag, err := pm.configETagLocked()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to read config")
+		return
+	}
+	if !checkIfMatch(w, r, etag) {
+		return
+	}
+	id := pm.cfg.Processes[idx].ID
+	if pm.scaling[id] {
+		writeError(w, http.StatusConflict, "changes to "+id+" are still being applied")
+		return
+	}
+
+	cfg := cloneConfig(pm.cfg)
+	cfg.Processes = slices.Delete(cfg.Processes, idx, idx+1)
+	entry := AuditEntry{Action: AuditConfigWrite, Target: id}
+	_, op, err := pm.applyConfigLocked(requestActor(r), cfg, configReason(r, "delete "+id), entry)
+	if err != nil {
+		pm.recordAudit(r, entry, err)
+		writeConfigError(w, err)
+		return
+	}
+	var resp definitionResponse
+	if op != nil {
+		s := pm.ops.snapshot(op)
+		resp.Operation = &s
+	}
+	etag, _ = pm.configETagLocked()
+	pm.writeDefinition(w, http.StatusOK, etag, resp)
+}
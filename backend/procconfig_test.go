@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// The examples from RFC 7386, appendix A, plus nested nulls
+	tests := []struct{ target, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{`{"env":{"A":"1","B":"2"}}`, `{"env":{"A":null}}`, `{"env":{"B":"2"}}`},
+		{`{"env":{"A":"1"}}`, `{"env":null}`, `{}`},
+		{`{"replicas":2}`, `{"replicas":0}`, `{"replicas":0}`},
+	}
+	for _, tt := range tests {
+		var target, patch, want any
+		for _, d := range []struct {
+			s string
+			v *any
+		}{{tt.target, &target}, {tt.patch, &patch}, {tt.want, &want}} {
+			if err := json.Unmarshal([]byte(d.s), d.v); err != nil {
+				t.Fatalf("bad test document %s: %v", d.s, err)
+			}
+		}
+		if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+			out, _ := json.Marshal(got)
+			t.Errorf("mergePatch(%s, %s) = %s, want %s", tt.target, tt.patch, out, tt.want)
+		}
+	}
+}
+
+func TestNeedsRestart(t *testing.T) {
+	base := ProcessConfig{
+		ID:         "web",
+		Name:       "Web",
+		Executable: "/usr/bin/web",
+		Args:       []string{"--port", "80"},
+		Category:   "web",
+		Env:        map[string]string{"A": "1"},
+	}
+	tests := []struct {
+		name   string
+		change func(pc *ProcessConfig)
+		want   bool
+	}{
+		{"unchanged", func(pc *ProcessConfig) {}, false},
+		{"name", func(pc *ProcessConfig) { pc.Name = "Website" }, false},
+		{"category", func(pc *ProcessConfig) { pc.Category = "other" }, false},
+		{"auto_restart", func(pc *ProcessConfig) { pc.AutoRestart = true }, false},
+		{"shutdown_delay", func(pc *ProcessConfig) { pc.ShutdownDelay = 30 }, false},
+		{"source file", func(pc *ProcessConfig) { pc.Source = "conf.d/web.yaml" }, false},
+		{"executable", func(pc *ProcessConfig) { pc.Executable = "/usr/local/bin/web" }, true},
+		{"args", func(pc *ProcessConfig) { pc.Args = []string{"--port", "8080"} }, true},
+		{"env value", func(pc *ProcessConfig) { pc.Env = map[string]string{"A": "2"} }, true},
+		{"working dir", func(pc *ProcessConfig) { pc.WorkingDir = "/srv" }, true},
+		{"user", func(pc *ProcessConfig) { pc.User = "nobody" }, true},
+		{"log rotation", func(pc *ProcessConfig) { pc.LogMaxSizeMB = 10 }, true},
+	}
+	for _, tt := range tests {
+		after := base
+		after.Args = append([]string{}, base.Args...)
+		tt.change(&after)
+		if got := needsRestart(base, after); got != tt.want {
+			t.Errorf("%s: needsRestart = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	// The arguments are copies: ignored fields must survive the comparison
+	if base.Name != "Web" || base.Category != "web" {
+		t.Errorf("needsRestart modified its argument: %+v", base)
+	}
+}
+
+func TestValidateConfigProcessIDs(t *testing.T) {
+	two := 2
+	tests := []struct {
+		id       string
+		replicas *int
+		ok       bool
+	}{
+		{"web", nil, true},
+		{"world-server_2.eu", nil, true},
+		{"web", &two, true},
+		{"../x", nil, false},
+		{"a/b", nil, false},
+		{`a\b`, nil, false},
+		{".hidden", nil, false},
+		{"", nil, false},
+		{"has space", nil, false},
+		{"../x", &two, false},
+	}
+	for _, tt := range tests {
+		cfg := &Config{Processes: []ProcessConfig{{ID: tt.id, Name: "P", Executable: "/bin/true", Replicas: tt.replicas}}}
+		if err := validateConfig(cfg); (err == nil) != tt.ok {
+			t.Errorf("validateConfig with id %q (replicas %v): err = %v, want ok %v", tt.id, tt.replicas, err, tt.ok)
+		}
+	}
+
+	for _, id := range []string{"web#1", "web#12"} {
+		if !validProcessID(id) {
+			t.Errorf("validProcessID(%q) = false", id)
+		}
+	}
+	for _, id := range []string{"web#0", "web#01", "web#x", "web#", "../x#1", "web#1#2"} {
+		if validProcessID(id) {
+			t.Errorf("validProcessID(%q) = true", id)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -9,9 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/shirou/gopsutil/v3/process"
 )
 
 type ProcessState string
@@ -24,7 +24,7 @@ const (
 )
 
 type ManagedProcess struct {
-	Config           ProcessConfig
+	config           atomic.Pointer[ProcessConfig] // replaced whole, never modified; see Config
 	State            ProcessState
 	PID              int32
 	CPU              float64
@@ -36,40 +36,73 @@ type ManagedProcess struct {
 	cmd              *exec.Cmd
 	mu               sync.Mutex
 	manualStop       bool
+	stopDone         chan struct{} // closed once a requested stop is done with the tree
 	metrics          *MetricsRingBuffer
+	exited           chan struct{}  // closed when the current exec process has exited
+	opLock           chan struct{}  // held for the duration of a start/stop/restart
+	restarting       atomic.Bool    // folds start/stop events into one restarted event
+	cgroup           *processCgroup // set while running with cgroup limits (Linux)
+	CrashReason      string         // why the last run crashed, cleared on start
+	TreeSize         int            // processes in the tree, including the root
+	sampler          treeSampler    // previous counters for I/O and fault rates
+	logPath          string         // absolute log path, resolved on first use
+	logSize          int64          // log size as of logStatAt
+	logStatAt        time.Time
+	stdin            *os.File      // write end of the process's stdin, nil when not running
+	consoleWrite     chan struct{} // held while console input is being written
 }
 
+// logStatInterval is how often getStatus re-reads a log file's size; it runs
+// for every process each tick.
+const logStatInterval = 10 * time.Second
+
 type ProcessStatus struct {
-	ID               string       `json:"id"`
-	Name             string       `json:"name"`
-	State            ProcessState `json:"state"`
-	PID              int32        `json:"pid"`
-	CPU              float64      `json:"cpu"`
-	MemoryMB         float64      `json:"memory_mb"`
-	Threads          int32        `json:"threads"`
-	StartedAt        int64        `json:"started_at"`        // unix ms, 0 if not running
-	StoppingDeadline int64        `json:"stopping_deadline"` // unix ms, 0 if not stopping
-	RestartCount     int          `json:"restart_count"`
-	AutoRestart      bool         `json:"auto_restart"`
-	Executable       string       `json:"executable"`
-	WorkingDir       string       `json:"working_dir"`
-	IsService        bool         `json:"is_service"`
-	Category         string       `json:"category"`
-	LogSizeBytes     int64        `json:"log_size_bytes"`
-	LogPath          string       `json:"log_path"`
+	ID               string         `json:"id"`
+	Name             string         `json:"name"`
+	State            ProcessState   `json:"state"`
+	PID              int32          `json:"pid"`
+	CPU              float64        `json:"cpu"`
+	MemoryMB         float64        `json:"memory_mb"`
+	Threads          int32          `json:"threads"`
+	StartedAt        int64          `json:"started_at"`        // unix ms, 0 if not running
+	StoppingDeadline int64          `json:"stopping_deadline"` // unix ms, 0 if not stopping
+	RestartCount     int            `json:"restart_count"`
+	CrashReason      string         `json:"crash_reason,omitempty"`
+	ReplicaOf        string         `json:"replica_of,omitempty"` // group (definition) ID for replicas
+	Replica          int            `json:"replica,omitempty"`
+	Ports            map[string]int `json:"ports,omitempty"`
+	TreeSize         int            `json:"tree_size"` // processes counted in cpu/memory/threads
+	AutoRestart      bool           `json:"auto_restart"`
+	Executable       string         `json:"executable"`
+	WorkingDir       string         `json:"working_dir"`
+	IsService        bool           `json:"is_service"`
+	Category         string         `json:"category"`
+	LogSizeBytes     int64          `json:"log_size_bytes"`
+	LogPath          string         `json:"log_path"`
 }
 
 type ProcessManager struct {
-	processes  map[string]*ManagedProcess
-	order      []string
-	mu         sync.RWMutex
-	hub        *WSHub
-	configPath string
-	cfg        *Config
-	events     *EventStore
+	processes   map[string]*ManagedProcess
+	order       []string
+	mu          sync.RWMutex
+	hub         *WSHub
+	configPath  string
+	cfg         *Config
+	events      *EventStore
+	audit       *AuditLog
+	secrets     *SecretStore
+	ops         *OperationManager
+	history     *ConfigHistory
+	configFiles []configFile // main config first, then included files
+	watch       configWatch
+	fileSum     [32]byte // hash of the config files as last loaded or written
+	rejectedSum [32]byte // config files state that last failed to reload
+	reloadErr   string   // why, while those files are still on disk
+	host        *HostMonitor
+	scaling     map[string]bool // groups with a scale operation in progress
 }
 
-func newProcessManager(cfg *Config, configPath string) *ProcessManager {
+func newProcessManager(cfg *Config, configPath string, secrets *SecretStore) *ProcessManager {
 	pm := &ProcessManager{
 		processes:  make(map[string]*ManagedProcess),
 		order:      make([]string, 0, len(cfg.Processes)),
@@ -77,21 +110,89 @@ func newProcessManager(cfg *Config, configPath string) *ProcessManager {
 		configPath: configPath,
 		cfg:        cfg,
 		events:     &EventStore{},
+		audit:      newAuditLog(auditLogPath),
+		history:    newConfigHistory(configHistoryPath),
+		secrets:    secrets,
+		host:       newHostMonitor(hostHistoryPath),
+		scaling:    make(map[string]bool),
 	}
-	for _, pc := range cfg.Processes {
-		pm.processes[pc.ID] = &ManagedProcess{
-			Config:  pc,
-			State:   StateStopped,
-			metrics: &MetricsRingBuffer{},
-		}
+	pm.ops = newOperationManager(pm.publishOperation, secrets.Redact)
+	pm.events.notify = pm.publishEvent
+	for _, pc := range expandProcesses(cfg.Processes) {
+		pm.processes[pc.ID] = newManagedProcess(pc)
 		pm.order = append(pm.order, pc.ID)
 	}
 	return pm
 }
 
+func newManagedProcess(pc ProcessConfig) *ManagedProcess {
+	mp := &ManagedProcess{
+		State:        StateStopped,
+		metrics:      &MetricsRingBuffer{},
+		opLock:       make(chan struct{}, 1),
+		consoleWrite: make(chan struct{}, 1),
+	}
+	mp.config.Store(&pc)
+	return mp
+}
+
+// Config returns the process's current definition. Definition edits swap in
+// a new one, so the result stays consistent but must not be modified; copy
+// it first.
+func (mp *ManagedProcess) Config() *ProcessConfig {
+	return mp.config.Load()
+}
+
+// updateConfig replaces the definition with a changed copy.
+func (mp *ManagedProcess) updateConfig(change func(pc *ProcessConfig)) {
+	for {
+		old := mp.config.Load()
+		pc := *old
+		change(&pc)
+		if mp.config.CompareAndSwap(old, &pc) {
+			return
+		}
+	}
+}
+
+// beginOp waits until no other start/stop/restart is running for the
+// process and claims it, or returns ctx's error if cancelled first. A
+// cancellation that races with the lock becoming free still wins.
+func (mp *ManagedProcess) beginOp(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case mp.opLock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := ctx.Err(); err != nil {
+		<-mp.opLock
+		return err
+	}
+	return nil
+}
+
+func (mp *ManagedProcess) endOp() {
+	<-mp.opLock
+}
+
+// recordEvent records a lifecycle event for mp. While a restart is in
+// progress its intermediate started/stopped events are folded into the
+// single restarted event recorded by restartProcess.
+func (pm *ProcessManager) recordEvent(mp *ManagedProcess, eventType string) {
+	if mp.restarting.Load() && (eventType == EventStarted || eventType == EventStopped) {
+		return
+	}
+	pm.events.Record(mp.Config().ID, mp.Config().Name, eventType)
+}
+
 func (pm *ProcessManager) run() {
 	go pm.hub.run()
 	go pm.monitor()
+	go pm.hostMonitor()
+	go pm.followLogs()
 }
 
 // ── Windows Service helpers ──────────────────────────────────────────────────
@@ -134,7 +235,7 @@ func (pm *ProcessManager) startServiceProcess(mp *ManagedProcess) error {
 		mp.mu.Unlock()
 		return nil
 	}
-	serviceName := mp.Config.ServiceName
+	serviceName := mp.Config().ServiceName
 	mp.mu.Unlock()
 
 	// Run net start without holding the lock — monitor loop will detect RUNNING state
@@ -142,12 +243,12 @@ func (pm *ProcessManager) startServiceProcess(mp *ManagedProcess) error {
 	if err != nil {
 		msg := strings.TrimSpace(string(out))
 		if strings.Contains(msg, "already been started") {
-			pm.events.Record(mp.Config.ID, mp.Config.Name, EventStarted)
+			pm.recordEvent(mp, EventStarted)
 			return nil
 		}
 		return fmt.Errorf("%w: %s", err, msg)
 	}
-	pm.events.Record(mp.Config.ID, mp.Config.Name, EventStarted)
+	pm.recordEvent(mp, EventStarted)
 	return nil
 }
 
@@ -158,7 +259,7 @@ func (pm *ProcessManager) stopServiceProcess(mp *ManagedProcess) error {
 		return nil
 	}
 	mp.State = StateStopping
-	serviceName := mp.Config.ServiceName
+	serviceName := mp.Config().ServiceName
 	mp.mu.Unlock()
 
 	// Run net stop without holding the lock — monitor loop will detect STOPPED state
@@ -183,7 +284,7 @@ func (pm *ProcessManager) stopServiceProcess(mp *ManagedProcess) error {
 		mp.mu.Unlock()
 		return fmt.Errorf("%w: %s", err, msg)
 	}
-	pm.events.Record(mp.Config.ID, mp.Config.Name, EventStopped)
+	pm.recordEvent(mp, EventStopped)
 	return nil
 }
 
@@ -198,6 +299,9 @@ func (pm *ProcessManager) startExecProcess(mp *ManagedProcess, manualStart bool)
 	if mp.State == StateRunning {
 		return nil
 	}
+	if mp.State == StateStopping {
+		return fmt.Errorf("process is still stopping")
+	}
 
 	if manualStart {
 		mp.RestartCount = 0
@@ -205,18 +309,39 @@ func (pm *ProcessManager) startExecProcess(mp *ManagedProcess, manualStart bool)
 		mp.RestartCount++
 	}
 	mp.StartedAt = time.Now()
+	mp.logStatAt = time.Time{} // the log may be rotated below
+
+	// One version of the definition for the whole launch, even if it is
+	// edited meanwhile
+	pc := mp.Config()
+	env, err := buildProcessEnv(pc, pm.secrets)
+	if err != nil {
+		return err
+	}
+	args := make([]string, len(pc.Args))
+	for i, arg := range pc.Args {
+		if args[i], err = pm.secrets.resolveSecretRefs(arg); err != nil {
+			return err
+		}
+	}
 
-	cmd := exec.Command(mp.Config.Executable, mp.Config.Args...)
-	if mp.Config.WorkingDir != "" {
-		cmd.Dir = mp.Config.WorkingDir
+	cmd := exec.Command(pc.Executable, args...)
+	if pc.WorkingDir != "" {
+		cmd.Dir = pc.WorkingDir
+	}
+	cmd.Env = env.environ()
+	ident, launch, err := applyIdentity(pc, cmd)
+	if err != nil {
+		return err
 	}
+	defer launch.close()
 
 	// Redirect stdout/stderr to separate log files for each process
-	logPath := fmt.Sprintf("./%s.log", mp.Config.ID)
+	logPath := fmt.Sprintf("./%s.log", pc.ID)
 
 	// Apply log rotation if configured
-	if mp.Config.LogMaxSizeMB > 0 {
-		_, err := rotateLog(logPath, mp.Config.LogMaxSizeMB, mp.Config.LogMaxBackups, mp.Config.LogMaxAgeDays)
+	if pc.LogMaxSizeMB > 0 {
+		_, err := rotateLog(logPath, pc.LogMaxSizeMB, pc.LogMaxBackups, pc.LogMaxAgeDays)
 		if err != nil {
 			return fmt.Errorf("failed to rotate log: %w", err)
 		}
@@ -230,8 +355,11 @@ func (pm *ProcessManager) startExecProcess(mp *ManagedProcess, manualStart bool)
 	if err != nil {
 		return fmt.Errorf("failed to open log file: %w", err)
 	}
+	if err := chownLog(logFile, ident); err != nil {
+		log.Printf("[process] %s: cannot hand log file to its user: %v", pc.ID, err)
+	}
 
-	// Create a pipe for stdin so the process can read but gets no input
+	// Create a pipe for stdin; the write end stays open for console input
 	stdinRead, stdinWrite, err := os.Pipe()
 	if err != nil {
 		logFile.Close()
@@ -242,26 +370,67 @@ func (pm *ProcessManager) startExecProcess(mp *ManagedProcess, manualStart bool)
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
-	if err := cmd.Start(); err != nil {
+	cg, releaseCgroup, err := prepareLimits(pc, cmd)
+	if err != nil {
+		logFile.Close()
+		stdinRead.Close()
+		stdinWrite.Close()
+		return err
+	}
+	setProcessGroup(cmd)
+	err = cmd.Start()
+	releaseCgroup()
+	if err == nil {
+		if err = launch.wait(); err != nil {
+			cmd.Wait()
+			err = fmt.Errorf("failed to launch: %w", err)
+		}
+	}
+	if err != nil {
 		logFile.Close()
 		stdinRead.Close()
 		stdinWrite.Close()
+		if cg != nil {
+			cg.remove()
+		}
 		return err
 	}
 
 	// Close the read end in parent; keep write end open so process can read indefinitely
 	stdinRead.Close()
 
+	exited := make(chan struct{})
 	mp.cmd = cmd
 	mp.PID = int32(cmd.Process.Pid)
 	mp.State = StateRunning
 	mp.manualStop = false
-	pm.events.Record(mp.Config.ID, mp.Config.Name, EventStarted)
+	mp.exited = exited
+	mp.cgroup = cg
+	mp.CrashReason = ""
+	mp.stdin = stdinWrite
+	pm.recordEvent(mp, EventStarted)
 
 	go func() {
 		cmd.Wait()
 		logFile.Close()
 		stdinWrite.Close()
+		// A requested stop gives the rest of the tree until its deadline to
+		// exit; only then, or straight away after a crash, is whatever is
+		// left of the group killed so no orphans stay behind
+		mp.mu.Lock()
+		stopDone := mp.stopDone
+		mp.mu.Unlock()
+		if stopDone != nil {
+			<-stopDone
+		}
+		reapProcessGroup(int32(cmd.Process.Pid))
+		crashReason := exitReason(cmd, cg)
+		if cg != nil {
+			cg.kill()
+			// Before the process counts as stopped, so a new start can't
+			// race with the removal
+			cg.remove()
+		}
 
 		mp.mu.Lock()
 		wasManual := mp.manualStop
@@ -269,36 +438,67 @@ func (pm *ProcessManager) startExecProcess(mp *ManagedProcess, manualStart bool)
 			mp.State = StateStopped
 		} else {
 			mp.State = StateCrashed
+			mp.CrashReason = crashReason
 		}
+		mp.stopDone = nil
+		mp.cgroup = nil
+		mp.stdin = nil
+		reason := mp.CrashReason
 		mp.PID = 0
 		mp.CPU = 0
 		mp.MemoryRSS = 0
 		mp.Threads = 0
+		mp.TreeSize = 0
+		mp.sampler.reset()
 		mp.StartedAt = time.Time{}
 		mp.StoppingDeadline = time.Time{}
-		shouldRestart := !wasManual && mp.Config.AutoRestart
+		shouldRestart := !wasManual && mp.Config().AutoRestart
 		mp.mu.Unlock()
+		close(exited)
 
 		if wasManual {
-			pm.events.Record(mp.Config.ID, mp.Config.Name, EventStopped)
+			pm.recordEvent(mp, EventStopped)
 		} else {
-			pm.events.Record(mp.Config.ID, mp.Config.Name, EventCrashed)
+			pm.events.RecordReason(mp.Config().ID, mp.Config().Name, EventCrashed, reason)
 		}
 
 		if shouldRestart {
-			log.Printf("[auto-restart] %s crashed — restarting in 3s", mp.Config.Name)
+			log.Printf("[auto-restart] %s crashed — restarting in 3s", mp.Config().Name)
 			time.Sleep(3 * time.Second)
-			if err := pm.startExecProcess(mp, false); err != nil {
-				log.Printf("[auto-restart] failed to restart %s: %v", mp.Config.Name, err)
-			}
+			pm.autoRestart(mp)
 		} else if !wasManual {
-			log.Printf("[crash] %s exited unexpectedly (auto-restart off)", mp.Config.Name)
+			log.Printf("[crash] %s exited unexpectedly (auto-restart off)", mp.Config().Name)
 		}
 	}()
 
 	return nil
 }
 
+// autoRestart relaunches a crashed process under its operation lock. The
+// process may have been stopped, started or removed while the restart was
+// pending, so it is only relaunched if it is still managed, still crashed
+// and still set to auto-restart.
+func (pm *ProcessManager) autoRestart(mp *ManagedProcess) {
+	if err := mp.beginOp(context.Background()); err != nil {
+		return
+	}
+	defer mp.endOp()
+
+	pm.mu.RLock()
+	managed := pm.processes[mp.Config().ID] == mp
+	pm.mu.RUnlock()
+	mp.mu.Lock()
+	pending := mp.State == StateCrashed && mp.Config().AutoRestart
+	mp.mu.Unlock()
+	if !managed || !pending {
+		log.Printf("[auto-restart] %s no longer needs restarting", mp.Config().Name)
+		return
+	}
+	if err := pm.startExecProcess(mp, false); err != nil {
+		log.Printf("[auto-restart] failed to restart %s: %v", mp.Config().Name, err)
+	}
+}
+
 func (pm *ProcessManager) stopExecProcess(mp *ManagedProcess) error {
 	// Capture state while holding lock, then release before polling/sleeping
 	mp.mu.Lock()
@@ -308,9 +508,12 @@ func (pm *ProcessManager) stopExecProcess(mp *ManagedProcess) error {
 	}
 
 	mp.manualStop = true
+	done := make(chan struct{})
+	mp.stopDone = done
+	defer close(done)
 	pid := mp.PID
-	proc := mp.cmd.Process
-	delay := mp.Config.ShutdownDelay
+	cg := mp.cgroup
+	delay := mp.Config().ShutdownDelay
 
 	// Set stopping state so frontend shows countdown
 	mp.State = StateStopping
@@ -319,29 +522,34 @@ func (pm *ProcessManager) stopExecProcess(mp *ManagedProcess) error {
 	}
 	mp.mu.Unlock()
 
+	// Remember the whole tree now; descendants lose their parent link once
+	// the root exits.
+	members := snapshotTree(pid)
+	forceKill := func() error {
+		signalTree(pid, members, true)
+		if cg != nil {
+			cg.kill()
+		}
+		return nil
+	}
+
 	// If no delay, kill immediately
 	if delay == 0 {
-		return proc.Kill()
+		return forceKill()
 	}
 
 	// Graceful shutdown with timeout
-	pidStr := strconv.FormatInt(int64(pid), 10)
-	softKillCmd := exec.Command("taskkill", "/PID", pidStr)
-	_ = softKillCmd.Run() // Soft kill, ignore errors
+	signalTree(pid, members, false)
 
-	// Poll for process exit with 500ms interval
+	// Poll for the tree to exit with 500ms interval
 	pollInterval := 500 * time.Millisecond
 	maxWait := time.Duration(delay) * time.Second
 	elapsed := time.Duration(0)
 
 	for elapsed < maxWait {
-		// Check if process is still alive via gopsutil
-		if p, err := process.NewProcess(pid); err == nil {
-			running, err := p.IsRunning()
-			if err == nil && !running {
-				// Process exited gracefully
-				return nil
-			}
+		if !anyAlive(members) {
+			// Tree exited gracefully
+			return nil
 		}
 
 		time.Sleep(pollInterval)
@@ -349,26 +557,131 @@ func (pm *ProcessManager) stopExecProcess(mp *ManagedProcess) error {
 	}
 
 	// Still running after timeout; force kill
-	log.Printf("[shutdown] %s did not exit gracefully after %ds; forcing kill", mp.Config.Name, delay)
-	return proc.Kill()
+	log.Printf("[shutdown] %s did not exit gracefully after %ds; forcing kill", mp.Config().Name, delay)
+	return forceKill()
 }
 
 // ── Public start / stop ──────────────────────────────────────────────────────
 
 func (pm *ProcessManager) startProcess(mp *ManagedProcess, manualStart bool) error {
-	if mp.Config.IsService {
+	if mp.Config().IsService {
 		return pm.startServiceProcess(mp)
 	}
 	return pm.startExecProcess(mp, manualStart)
 }
 
 func (pm *ProcessManager) stopProcess(mp *ManagedProcess) error {
-	if mp.Config.IsService {
+	if mp.Config().IsService {
 		return pm.stopServiceProcess(mp)
 	}
 	return pm.stopExecProcess(mp)
 }
 
+// setAutoRestart updates a process's auto-restart flag in memory and in
+// config.json, returning the previous value and whether it changed.
+func (pm *ProcessManager) setAutoRestart(a actor, mp *ManagedProcess, enabled bool) (before, changed bool) {
+	mp.mu.Lock()
+	before = mp.Config().AutoRestart
+	mp.updateConfig(func(pc *ProcessConfig) { pc.AutoRestart = enabled })
+	id := mp.Config().ID
+	mp.mu.Unlock()
+
+	pm.mu.Lock()
+	// Replicas have no definition of their own; their flag is not persisted
+	if idx := pm.definitionIndexLocked(id); idx >= 0 && pm.cfg.Processes[idx].AutoRestart != enabled {
+		pm.cfg.Processes[idx].AutoRestart = enabled
+		if err := pm.writeConfigLocked(a, pm.cfg, fmt.Sprintf("set auto_restart=%t on %s", enabled, id)); err != nil {
+			log.Printf("[config] auto_restart for %s not saved: %v", id, err)
+		}
+	}
+	pm.mu.Unlock()
+
+	return before, before != enabled
+}
+
+// restartStopTimeout bounds how long restartProcess waits for the process to
+// exit after the stop (including any shutdown_delay) has been issued.
+const restartStopTimeout = 30 * time.Second
+
+// restartProcess stops mp, waits for it to fully exit and starts it again.
+// Unlike a manual stop it leaves AutoRestart untouched, and it records a
+// single restarted event carrying the total duration. The caller must hold
+// the process's operation lock.
+func (pm *ProcessManager) restartProcess(mp *ManagedProcess) (time.Duration, error) {
+	begin := time.Now()
+	mp.restarting.Store(true)
+	defer mp.restarting.Store(false)
+
+	mp.mu.Lock()
+	exited := mp.exited
+	mp.mu.Unlock()
+
+	if err := pm.stopProcess(mp); err != nil {
+		return time.Since(begin), fmt.Errorf("stop failed: %w", err)
+	}
+	if err := pm.waitStopped(mp, exited); err != nil {
+		return time.Since(begin), err
+	}
+	if err := pm.startProcess(mp, true); err != nil {
+		err = fmt.Errorf("start failed: %w", err)
+		pm.recordRestartFailed(mp, err)
+		return time.Since(begin), err
+	}
+
+	d := time.Since(begin)
+	pm.events.RecordTimed(mp.Config().ID, mp.Config().Name, EventRestarted, d)
+	return d, nil
+}
+
+// recordRestartFailed records the stop that a restart's started event would
+// have folded away, with the reason the start failed, so the timeline shows
+// the process went down.
+func (pm *ProcessManager) recordRestartFailed(mp *ManagedProcess, err error) {
+	mp.restarting.Store(false)
+	pm.events.RecordReason(mp.Config().ID, mp.Config().Name, EventStopped, err.Error())
+}
+
+// waitStopped blocks until a stop issued by stopProcess has completed.
+// exited is the exec process's exit channel captured before the stop.
+func (pm *ProcessManager) waitStopped(mp *ManagedProcess, exited chan struct{}) error {
+	if !mp.Config().IsService {
+		if exited == nil {
+			return nil // never started
+		}
+		select {
+		case <-exited:
+			return nil
+		case <-time.After(restartStopTimeout):
+			return fmt.Errorf("timed out waiting for process to exit")
+		}
+	}
+
+	mp.mu.Lock()
+	stopped := mp.State == StateStopped
+	mp.mu.Unlock()
+	if stopped {
+		return nil
+	}
+
+	// net stop usually returns once the service has stopped, but poll
+	// until SCM agrees so the following net start isn't rejected.
+	deadline := time.Now().Add(restartStopTimeout)
+	for {
+		state, _, err := queryServiceStatus(mp.Config().ServiceName)
+		if err == nil && state == StateStopped {
+			mp.mu.Lock()
+			mp.State = StateStopped
+			mp.PID = 0
+			mp.mu.Unlock()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for service to stop")
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
 // ── Monitor loop ─────────────────────────────────────────────────────────────
 
 func (pm *ProcessManager) monitor() {
@@ -376,21 +689,22 @@ func (pm *ProcessManager) monitor() {
 	for range ticker.C {
 		pm.mu.RLock()
 		statuses := make([]ProcessStatus, 0, len(pm.order))
+		host := &hostSnapshot{} // process table and sockets, read on first use each tick
 
 		for _, id := range pm.order {
 			mp := pm.processes[id]
 			mp.mu.Lock()
 
-			if mp.Config.IsService {
+			if mp.Config().IsService {
 				// Services: poll sc queryex each tick for live state + PID
-				state, pid, err := queryServiceStatus(mp.Config.ServiceName)
+				state, pid, err := queryServiceStatus(mp.Config().ServiceName)
 				if err == nil {
 					if mp.State == StateStopping {
 						// Respect stopping state: only transition when fully stopped
 						if state == StateStopped {
 							mp.State = StateStopped
 							mp.PID = 0
-							pm.events.Record(mp.Config.ID, mp.Config.Name, EventStopped)
+							pm.recordEvent(mp, EventStopped)
 						} else {
 							// STOP_PENDING or still RUNNING during shutdown — keep stopping
 							mp.PID = pid
@@ -407,22 +721,31 @@ func (pm *ProcessManager) monitor() {
 				}
 			}
 
-			// CPU / memory / threads via gopsutil (also during stopping — process is still alive)
+			// CPU / memory / threads via gopsutil (also during stopping — process is still alive),
+			// summed over the process and all its descendants
 			if (mp.State == StateRunning || mp.State == StateStopping) && mp.PID > 0 {
-				p, err := process.NewProcess(mp.PID)
-				if err == nil {
-					cpu, _ := p.CPUPercent()
-					mem, _ := p.MemoryInfo()
-					threads, _ := p.NumThreads()
-
-					mp.CPU = cpu
-					if mem != nil {
-						mp.MemoryRSS = mem.RSS
+				point, rss, count := mp.sampler.sample(host, mp.PID)
+				if count > 0 {
+					mp.CPU = point.CPU
+					mp.MemoryRSS = rss
+					mp.Threads = point.Threads
+					mp.TreeSize = count
+
+					// Cgroup accounting covers every process the child spawned
+					if mp.cgroup != nil {
+						if cgCPU, cgMem, ok := mp.cgroup.sample(); ok {
+							mp.CPU = cgCPU
+							mp.MemoryRSS = cgMem
+							point.CPU = cgCPU
+							point.MemMB = float64(cgMem) / 1024 / 1024
+						}
 					}
-					mp.Threads = threads
 
 					// Push metrics to ring buffer
-					mp.metrics.Push(cpu, float64(mp.MemoryRSS)/1024/1024)
+					mp.metrics.Push(point)
+					if last := mp.metrics.Last(1); len(last) == 1 {
+						pm.publishMetrics(mp.Config(), last[0])
+					}
 				}
 			}
 
@@ -431,7 +754,7 @@ func (pm *ProcessManager) monitor() {
 		}
 
 		pm.mu.RUnlock()
-		pm.hub.broadcast(statuses)
+		pm.hub.broadcastStatuses(statuses)
 	}
 }
 
@@ -444,20 +767,10 @@ func (pm *ProcessManager) getStatus(mp *ManagedProcess) ProcessStatus {
 	if !mp.StoppingDeadline.IsZero() {
 		stoppingDeadline = mp.StoppingDeadline.UnixMilli()
 	}
-	var logSizeBytes int64
-	var logPath string
-	if !mp.Config.IsService {
-		p := fmt.Sprintf("./%s.log", mp.Config.ID)
-		if abs, err := filepath.Abs(p); err == nil {
-			logPath = abs
-		}
-		if info, err := os.Stat(p); err == nil {
-			logSizeBytes = info.Size()
-		}
-	}
+	logPath, logSizeBytes := mp.logInfo()
 	return ProcessStatus{
-		ID:               mp.Config.ID,
-		Name:             mp.Config.Name,
+		ID:               mp.Config().ID,
+		Name:             mp.Config().Name,
 		State:            mp.State,
 		PID:              mp.PID,
 		CPU:              mp.CPU,
@@ -466,12 +779,39 @@ func (pm *ProcessManager) getStatus(mp *ManagedProcess) ProcessStatus {
 		StartedAt:        startedAt,
 		StoppingDeadline: stoppingDeadline,
 		RestartCount:     mp.RestartCount,
-		AutoRestart:      mp.Config.AutoRestart,
-		Executable:       mp.Config.Executable,
-		WorkingDir:       mp.Config.WorkingDir,
-		IsService:        mp.Config.IsService,
-		Category:         mp.Config.Category,
+		CrashReason:      mp.CrashReason,
+		ReplicaOf:        mp.Config().ReplicaOf,
+		Replica:          mp.Config().Replica,
+		Ports:            mp.Config().Ports,
+		TreeSize:         mp.TreeSize,
+		AutoRestart:      mp.Config().AutoRestart,
+		Executable:       mp.Config().Executable,
+		WorkingDir:       mp.Config().WorkingDir,
+		IsService:        mp.Config().IsService,
+		Category:         mp.Config().Category,
 		LogSizeBytes:     logSizeBytes,
 		LogPath:          logPath,
 	}
 }
+
+// logInfo returns the process's absolute log path and size, re-reading the
+// size at most every logStatInterval. Caller must hold mp.mu.
+func (mp *ManagedProcess) logInfo() (string, int64) {
+	if mp.Config().IsService {
+		return "", 0
+	}
+	p := fmt.Sprintf("./%s.log", mp.Config().ID)
+	if mp.logPath == "" {
+		if abs, err := filepath.Abs(p); err == nil {
+			mp.logPath = abs
+		}
+	}
+	if time.Since(mp.logStatAt) >= logStatInterval {
+		mp.logSize = 0
+		if info, err := os.Stat(p); err == nil {
+			mp.logSize = info.Size()
+		}
+		mp.logStatAt = time.Now()
+	}
+	return mp.logPath, mp.logSize
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ProcessNode is one process in a managed process's descendant tree.
+type ProcessNode struct {
+	PID      int32         `json:"pid"`
+	Name     string        `json:"name"`
+	Cmdline  string        `json:"cmdline"`
+	CPU      float64       `json:"cpu"`
+	MemoryMB float64       `json:"memory_mb"`
+	Threads  int32         `json:"threads"`
+	Children []ProcessNode `json:"children,omitempty"`
+}
+
+// treeMember identifies a process by PID and creation time, so a PID the
+// OS has since reused is not mistaken for the original.
+type treeMember struct {
+	pid     int32
+	created int64
+}
+
+// childrenMap indexes every process on the host by parent PID.
+func childrenMap() map[int32][]int32 {
+	pids, err := process.Pids()
+	if err != nil {
+		return nil
+	}
+	children := make(map[int32][]int32)
+	for _, pid := range pids {
+		p, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		if ppid, err := p.Ppid(); err == nil && ppid != pid {
+			children[ppid] = append(children[ppid], pid)
+		}
+	}
+	return children
+}
+
+// descendantPIDs returns every descendant of pid, breadth first.
+func descendantPIDs(children map[int32][]int32, pid int32) []int32 {
+	var out []int32
+	queue := append([]int32(nil), children[pid]...)
+	seen := map[int32]bool{pid: true}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		if seen[next] {
+			continue
+		}
+		seen[next] = true
+		out = append(out, next)
+		queue = append(queue, children[next]...)
+	}
+	return out
+}
+
+// snapshotTree records pid and its current descendants, so that they can
+// still be found once the root exits and they are reparented.
+func snapshotTree(pid int32) []treeMember {
+	pids := append([]int32{pid}, descendantPIDs(childrenMap(), pid)...)
+	members := make([]treeMember, 0, len(pids))
+	for _, p := range pids {
+		if proc, err := process.NewProcess(p); err == nil {
+			created, _ := proc.CreateTime()
+			members = append(members, treeMember{pid: p, created: created})
+		}
+	}
+	return members
+}
+
+func (m treeMember) alive() bool {
+	p, err := process.NewProcess(m.pid)
+	if err != nil {
+		return false
+	}
+	if created, err := p.CreateTime(); err == nil && created != m.created {
+		return false
+	}
+	running, err := p.IsRunning()
+	return err == nil && running
+}
+
+func anyAlive(members []treeMember) bool {
+	for _, m := range members {
+		if m.alive() {
+			return true
+		}
+	}
+	return false
+}
+
+func buildProcessNode(children map[int32][]int32, pid int32, seen map[int32]bool) ProcessNode {
+	seen[pid] = true
+	node := ProcessNode{PID: pid}
+	if p, err := process.NewProcess(pid); err == nil {
+		node.Name, _ = p.Name()
+		if args, err := p.CmdlineSlice(); err == nil {
+			node.Cmdline = strings.Join(args, " ")
+		}
+		node.CPU, _ = p.CPUPercent()
+		if mem, err := p.MemoryInfo(); err == nil && mem != nil {
+			node.MemoryMB = float64(mem.RSS) / 1024 / 1024
+		}
+		node.Threads, _ = p.NumThreads()
+	}
+	for _, child := range children[pid] {
+		if !seen[child] {
+			node.Children = append(node.Children, buildProcessNode(children, child, seen))
+		}
+	}
+	return node
+}
+
+func (pm *ProcessManager) handleGetTree(w http.ResponseWriter, r *http.Request) {
+	pm.mu.RLock()
+	mp, ok := pm.processes[r.PathValue("id")]
+	pm.mu.RUnlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "process not found")
+		return
+	}
+	if !authorize(w, r, PermView, mp.Config()) {
+		return
+	}
+
+	mp.mu.Lock()
+	pid := mp.PID
+	mp.mu.Unlock()
+
+	if pid <= 0 {
+		writeJSON(w, http.StatusOK, map[string]any{"tree": nil})
+		return
+	}
+	tree := buildProcessNode(childrenMap(), pid, make(map[int32]bool))
+	// Command lines may carry resolved ${secret:...} arguments
+	var redact func(n *ProcessNode)
+	redact = func(n *ProcessNode) {
+		n.Cmdline = pm.secrets.Redact(n.Cmdline)
+		for i := range n.Children {
+			redact(&n.Children[i])
+		}
+	}
+	redact(&tree)
+	writeJSON(w, http.StatusOK, map[string]any{"tree": tree})
+}
@@ -0,0 +1,38 @@
+//go:build unix
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the child as leader of its own process group, so
+// the whole tree can be signalled at once.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalTree asks the tree to exit (SIGTERM), or kills it (SIGKILL) when
+// force is set. The process group covers most descendants; members that
+// moved to another group or session are signalled individually.
+func signalTree(pid int32, members []treeMember, force bool) {
+	sig := syscall.SIGTERM
+	if force {
+		sig = syscall.SIGKILL
+	}
+	syscall.Kill(-int(pid), sig)
+	for _, m := range members {
+		if m.alive() {
+			syscall.Kill(int(m.pid), sig)
+		}
+	}
+}
+
+// reapProcessGroup kills whatever is left of a group after its leader exits.
+func reapProcessGroup(pid int32) {
+	syscall.Kill(-int(pid), syscall.SIGKILL)
+}
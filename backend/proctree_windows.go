@@ -0,0 +1,37 @@
+//go:build windows
+
+package main
+
+import (
+	"os/exec"
+	"strconv"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// signalTree uses taskkill /T, which walks the tree from pid. Members that
+// were orphaned (their parent already exited) are handled individually.
+func signalTree(pid int32, members []treeMember, force bool) {
+	args := []string{"/T", "/PID", strconv.FormatInt(int64(pid), 10)}
+	if force {
+		args = append([]string{"/F"}, args...)
+	}
+	_ = exec.Command("taskkill", args...).Run()
+
+	if !force {
+		return
+	}
+	for _, m := range members {
+		if m.alive() {
+			if p, err := process.NewProcess(m.pid); err == nil {
+				p.Kill()
+			}
+		}
+	}
+}
+
+// reapProcessGroup is a no-op: Windows has no process groups to signal once
+// the root has exited.
+func reapProcessGroup(pid int32) {}
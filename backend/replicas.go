@@ -0,0 +1,441 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxReplicas = 64
+
+// Group actions
+const (
+	OpGroupStart = "group_start"
+	OpGroupStop  = "group_stop"
+	OpScale      = "scale"
+	OpRemove     = "remove" // stop processes and drop them from the manager
+)
+
+// InstanceConfig overrides fields of a replicated process for one replica.
+// Args and working_dir replace the definition's, env is merged over it.
+type InstanceConfig struct {
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	WorkingDir string            `json:"working_dir,omitempty"`
+	Ports      map[string]int    `json:"ports,omitempty"`
+}
+
+// replicated reports whether the definition expands into numbered replicas.
+func (pc *ProcessConfig) replicated() bool {
+	return pc.Replicas != nil || len(pc.Instances) > 0
+}
+
+func (pc *ProcessConfig) replicaCount() int {
+	if pc.Replicas != nil {
+		return *pc.Replicas
+	}
+	return len(pc.Instances)
+}
+
+func replicaID(base string, n int) string {
+	return base + "#" + strconv.Itoa(n)
+}
+
+// replicaBase returns the definition ID a process ID belongs to.
+func replicaBase(id string) string {
+	base, _, _ := strings.Cut(id, "#")
+	return base
+}
+
+// expandProcesses turns definitions into the processes to manage.
+func expandProcesses(defs []ProcessConfig) []ProcessConfig {
+	out := make([]ProcessConfig, 0, len(defs))
+	for _, pc := range defs {
+		out = append(out, expandProcess(pc)...)
+	}
+	return out
+}
+
+// expandProcess returns one config per replica, or the definition itself
+// (with its ports applied) when it is not replicated.
+func expandProcess(pc ProcessConfig) []ProcessConfig {
+	if !pc.replicated() {
+		return []ProcessConfig{instanceConfig(pc, 0)}
+	}
+	out := make([]ProcessConfig, 0, pc.replicaCount())
+	for n := 1; n <= pc.replicaCount(); n++ {
+		out = append(out, instanceConfig(pc, n))
+	}
+	return out
+}
+
+// instanceConfig builds replica n of pc (n = 0 for an unreplicated
+// process). "{replica}" and "{port:NAME}" are substituted in args,
+// working_dir, env values and env_files, and each port is exported as an
+// environment variable of the same name unless env sets it.
+func instanceConfig(pc ProcessConfig, n int) ProcessConfig {
+	rc := pc
+	env := maps.Clone(pc.Env)
+	ports := pc.Ports
+	pairs := []string{}
+
+	if n > 0 {
+		rc.ID = replicaID(pc.ID, n)
+		rc.Name = fmt.Sprintf("%s #%d", pc.Name, n)
+		rc.ReplicaOf, rc.Replica = pc.ID, n
+		rc.Replicas, rc.Instances = nil, nil
+		if n <= len(pc.Instances) {
+			inst := pc.Instances[n-1]
+			if inst.Args != nil {
+				rc.Args = inst.Args
+			}
+			if inst.WorkingDir != "" {
+				rc.WorkingDir = inst.WorkingDir
+			}
+			if len(inst.Env) > 0 && env == nil {
+				env = make(map[string]string)
+			}
+			maps.Copy(env, inst.Env)
+			if inst.Ports != nil {
+				ports = inst.Ports
+			}
+		}
+		pairs = append(pairs, "{replica}", strconv.Itoa(n))
+	}
+	for name, port := range ports {
+		pairs = append(pairs, "{port:"+name+"}", strconv.Itoa(port))
+	}
+	rc.Ports = maps.Clone(ports)
+
+	if len(pairs) > 0 {
+		sub := strings.NewReplacer(pairs...)
+		rc.Args = slices.Clone(rc.Args)
+		for i := range rc.Args {
+			rc.Args[i] = sub.Replace(rc.Args[i])
+		}
+		rc.WorkingDir = sub.Replace(rc.WorkingDir)
+		rc.EnvFiles = slices.Clone(rc.EnvFiles)
+		for i := range rc.EnvFiles {
+			rc.EnvFiles[i] = sub.Replace(rc.EnvFiles[i])
+		}
+		for k, v := range env {
+			env[k] = sub.Replace(v)
+		}
+	}
+	if !pc.IsService && (n > 0 || len(ports) > 0) {
+		if env == nil {
+			env = make(map[string]string)
+		}
+		if n > 0 {
+			if _, ok := env["SM_REPLICA"]; !ok {
+				env["SM_REPLICA"] = strconv.Itoa(n)
+			}
+		}
+		for name, port := range ports {
+			if _, ok := env[name]; !ok {
+				env[name] = strconv.Itoa(port)
+			}
+		}
+	}
+	rc.Env = env
+	return rc
+}
+
+// validateReplicas checks replica settings, that expanded IDs are unique
+// and that no port is assigned twice.
+func validateReplicas(cfg *Config) error {
+	for _, pc := range cfg.Processes {
+		if strings.Contains(pc.ID, "#") {
+			return fmt.Errorf("%s: process IDs may not contain '#'", pc.ID)
+		}
+		if pc.Replicas != nil && (*pc.Replicas < 0 || *pc.Replicas > maxReplicas) {
+			return fmt.Errorf("%s: replicas must be between 0 and %d", pc.ID, maxReplicas)
+		}
+		if len(pc.Instances) > maxReplicas {
+			return fmt.Errorf("%s: at most %d instances are allowed", pc.ID, maxReplicas)
+		}
+		if pc.IsService && pc.replicated() {
+			return fmt.Errorf("%s: replicas are not supported for services", pc.ID)
+		}
+		portSets := []map[string]int{pc.Ports}
+		for _, inst := range pc.Instances {
+			portSets = append(portSets, inst.Ports)
+		}
+		for _, ports := range portSets {
+			for name, port := range ports {
+				if !validEnvName(name) {
+					return fmt.Errorf("%s: invalid port name %q", pc.ID, name)
+				}
+				if port < 1 || port > 65535 {
+					return fmt.Errorf("%s: port %s must be between 1 and 65535", pc.ID, name)
+				}
+			}
+		}
+	}
+
+	ids := make(map[string]bool)
+	owners := make(map[int]string)
+	for _, pc := range expandProcesses(cfg.Processes) {
+		if ids[pc.ID] {
+			return fmt.Errorf("duplicate process ID: %s", pc.ID)
+		}
+		ids[pc.ID] = true
+		for _, port := range pc.Ports {
+			if owner, ok := owners[port]; ok {
+				return fmt.Errorf("port %d is assigned to both %s and %s", port, owner, pc.ID)
+			}
+			owners[port] = pc.ID
+		}
+	}
+	return nil
+}
+
+// ── Groups ───────────────────────────────────────────────────────────────────
+
+// definitionIndexLocked finds a definition in pm.cfg, or returns -1.
+// The caller must hold pm.mu.
+func (pm *ProcessManager) definitionIndexLocked(id string) int {
+	return slices.IndexFunc(pm.cfg.Processes, func(pc ProcessConfig) bool { return pc.ID == id })
+}
+
+// groupMembersLocked returns the managed replicas of a definition ordered
+// by replica number. The caller must hold pm.mu.
+func (pm *ProcessManager) groupMembersLocked(id string) []*ManagedProcess {
+	var members []*ManagedProcess
+	for _, mp := range pm.processes {
+		if mp.Config().ReplicaOf == id {
+			members = append(members, mp)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Config().Replica < members[j].Config().Replica })
+	return members
+}
+
+// insertOrderLocked places a new replica after the processes of earlier
+// definitions and after lower-numbered replicas of its own group.
+func (pm *ProcessManager) insertOrderLocked(defIdx int, mp *ManagedProcess) {
+	earlier := make(map[string]bool)
+	for _, pc := range pm.cfg.Processes[:defIdx] {
+		earlier[pc.ID] = true
+	}
+	pos := 0
+	for i, id := range pm.order {
+		other := pm.processes[id]
+		if earlier[replicaBase(id)] ||
+			(other != nil && other.Config().ReplicaOf == mp.Config().ReplicaOf && other.Config().Replica < mp.Config().Replica) {
+			pos = i + 1
+		}
+	}
+	pm.order = slices.Insert(pm.order, pos, mp.Config().ID)
+}
+
+// lookupGroup finds a replicated definition and its current replicas,
+// writing an error response if it does not exist or perm is missing.
+func (pm *ProcessManager) lookupGroup(w http.ResponseWriter, r *http.Request, perm string) (ProcessConfig, []*ManagedProcess, bool) {
+	id := r.PathValue("id")
+	pm.mu.RLock()
+	idx := pm.definitionIndexLocked(id)
+	var def ProcessConfig
+	var members []*ManagedProcess
+	if idx >= 0 {
+		def = pm.cfg.Processes[idx]
+		members = pm.groupMembersLocked(id)
+	}
+	pm.mu.RUnlock()
+
+	if idx < 0 || !def.replicated() {
+		writeError(w, http.StatusNotFound, "group not found")
+		return def, nil, false
+	}
+	if !authorize(w, r, perm, &def) {
+		return def, nil, false
+	}
+	return def, members, true
+}
+
+// submitGroupOperation is submitOperation with the group recorded as the
+// audit target.
+func (pm *ProcessManager) submitGroupOperation(w http.ResponseWriter, r *http.Request, group, action string, targets []*ManagedProcess) {
+	op, err := pm.submitOperation(requestActor(r), action, targets)
+	if err != nil {
+		if conflict, ok := err.(*opConflictError); ok {
+			writeJSON(w, http.StatusConflict, map[string]string{
+				"error":        conflict.Error(),
+				"operation_id": conflict.OperationID,
+			})
+			return
+		}
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pm.ops.mu.Lock()
+	op.target = group
+	pm.ops.mu.Unlock()
+	pm.respondOperation(w, r, op)
+}
+
+func (pm *ProcessManager) handleGroupStart(w http.ResponseWriter, r *http.Request) {
+	if def, members, ok := pm.lookupGroup(w, r, PermControl); ok {
+		pm.submitGroupOperation(w, r, def.ID, OpGroupStart, members)
+	}
+}
+
+// handleGroupStop stops replicas from the highest number down.
+func (pm *ProcessManager) handleGroupStop(w http.ResponseWriter, r *http.Request) {
+	if def, members, ok := pm.lookupGroup(w, r, PermControl); ok {
+		slices.Reverse(members)
+		pm.submitGroupOperation(w, r, def.ID, OpGroupStop, members)
+	}
+}
+
+// handleScaleGroup changes a group's replica count and saves it to
+// config.json. New replicas are added stopped, and started if any replica
+// of the group is running; surplus replicas are stopped and removed,
+// highest number first.
+func (pm *ProcessManager) handleScaleGroup(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Replicas *int `json:"replicas"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Replicas == nil {
+		writeError(w, http.StatusBadRequest, `body must be {"replicas": N}`)
+		return
+	}
+	n := *body.Replicas
+	if n < 0 || n > maxReplicas {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("replicas must be between 0 and %d", maxReplicas))
+		return
+	}
+	def, _, ok := pm.lookupGroup(w, r, PermControl)
+	if !ok {
+		return
+	}
+	id := def.ID
+
+	pm.mu.Lock()
+	idx := pm.definitionIndexLocked(id)
+	if idx < 0 {
+		pm.mu.Unlock()
+		writeError(w, http.StatusNotFound, "group not found")
+		return
+	}
+	if pm.scaling[id] {
+		pm.mu.Unlock()
+		writeError(w, http.StatusConflict, "a scale operation is already running for "+id)
+		return
+	}
+
+	// Save the new count before touching the process table, so a failed
+	// write leaves everything as it was
+	before := pm.cfg.Processes[idx].replicaCount()
+	cfg := *pm.cfg
+	cfg.Processes = slices.Clone(pm.cfg.Processes)
+	cfg.Processes[idx].Replicas = &n
+	if err := validateConfig(&cfg); err != nil {
+		pm.mu.Unlock()
+		writeConfigError(w, err)
+		return
+	}
+	if err := pm.writeConfigLocked(requestActor(r), &cfg, fmt.Sprintf("scale %s to %d", id, n)); err != nil {
+		pm.mu.Unlock()
+		if errors.Is(err, errConfigConflict) || errors.Is(err, errConfigLocked) {
+			writeConfigError(w, err)
+		} else {
+			writeError(w, http.StatusInternalServerError, "failed to write config: "+err.Error())
+		}
+		return
+	}
+	pm.cfg = &cfg
+
+	members := pm.groupMembersLocked(id)
+	existing := make(map[int]bool, len(members))
+	running := false
+	for _, mp := range members {
+		existing[mp.Config().Replica] = true
+		mp.mu.Lock()
+		running = running || mp.State == StateRunning
+		mp.mu.Unlock()
+	}
+
+	var targets []*ManagedProcess
+	var steps []OperationStep
+	var added []*ManagedProcess
+	for _, pc := range expandProcess(pm.cfg.Processes[idx]) {
+		if existing[pc.Replica] {
+			continue
+		}
+		mp := newManagedProcess(pc)
+		pm.processes[pc.ID] = mp
+		pm.insertOrderLocked(idx, mp)
+		added = append(added, mp)
+		if running {
+			targets = append(targets, mp)
+			steps = append(steps, OperationStep{ProcessID: pc.ID, Action: OpStart, State: OpPending})
+		}
+	}
+	for i := len(members) - 1; i >= 0; i-- {
+		if members[i].Config().Replica > n {
+			targets = append(targets, members[i])
+			steps = append(steps, OperationStep{ProcessID: members[i].Config().ID, Action: OpRemove, State: OpPending})
+		}
+	}
+	pm.scaling[id] = true
+	pm.mu.Unlock()
+
+	for _, mp := range added {
+		pm.events.Record(mp.Config().ID, mp.Config().Name, EventAdded)
+	}
+
+	// Scale operations are serialised per group above, so create cannot
+	// conflict with another one.
+	op, err := pm.ops.create(requestActor(r), OpScale, steps)
+	if err != nil {
+		pm.mu.Lock()
+		delete(pm.scaling, id)
+		pm.mu.Unlock()
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pm.ops.mu.Lock()
+	op.target = id
+	op.changes = []ConfigChange{{Path: fmt.Sprintf("processes[%s].replicas", id), Before: before, After: n}}
+	pm.ops.mu.Unlock()
+
+	go func() {
+		pm.runOperation(op, targets)
+		pm.mu.Lock()
+		delete(pm.scaling, id)
+		pm.mu.Unlock()
+	}()
+	pm.respondOperation(w, r, op)
+}
+
+// removeProcess stops a surplus replica, or a process whose definition was
+// deleted, and drops it with its metrics from the manager. Its log file is
+// kept. The caller must hold the process's operation lock.
+func (pm *ProcessManager) removeProcess(mp *ManagedProcess) error {
+	mp.mu.Lock()
+	mp.updateConfig(func(pc *ProcessConfig) { pc.AutoRestart = false })
+	exited := mp.exited
+	mp.mu.Unlock()
+
+	if err := pm.stopProcess(mp); err != nil {
+		return fmt.Errorf("stop failed: %w", err)
+	}
+	if err := pm.waitStopped(mp, exited); err != nil {
+		return err
+	}
+
+	id := mp.Config().ID
+	pm.mu.Lock()
+	delete(pm.processes, id)
+	pm.order = slices.DeleteFunc(pm.order, func(other string) bool { return other == id })
+	pm.mu.Unlock()
+	pm.events.Record(id, mp.Config().Name, EventRemoved)
+	return nil
+}
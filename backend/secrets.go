@@ -0,0 +1,480 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	secretsPath          = "secrets.json"
+	secretsKeyPath       = "secrets.key"
+	secretsKDFIterations = 600000
+	secretsCheckText     = "server-manager secrets"
+	secretRefPrefix      = "secret:"
+	secretMaxValueBytes  = 64 * 1024
+
+	// Values shorter than this are not redacted from logs; masking every
+	// occurrence of "1" or "ab" would make output unreadable.
+	secretMinRedactLen = 4
+)
+
+// Audit actions for the secrets store
+const (
+	AuditSecretSet    = "secret_set"
+	AuditSecretDelete = "secret_delete"
+)
+
+var (
+	secretNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+	secretRefPattern  = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+)
+
+// SecretInfo is what the API reveals about a secret: never its value.
+type SecretInfo struct {
+	Name        string `json:"name"`
+	CreatedAtMS int64  `json:"created_at_ms"`
+	UpdatedAtMS int64  `json:"updated_at_ms"`
+}
+
+type secretRecord struct {
+	Value       string `json:"value"` // base64 nonce || AES-GCM ciphertext
+	CreatedAtMS int64  `json:"created_at_ms"`
+	UpdatedAtMS int64  `json:"updated_at_ms"`
+}
+
+type secretsKDF struct {
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations"`
+}
+
+type secretsFile struct {
+	Version int                      `json:"version"`
+	KDF     *secretsKDF              `json:"kdf,omitempty"`
+	Check   string                   `json:"check"`
+	Secrets map[string]*secretRecord `json:"secrets"`
+}
+
+// SecretStore keeps named secrets encrypted at rest with AES-256-GCM. The
+// key comes from SM_SECRETS_PASSPHRASE (via PBKDF2) when set, otherwise from
+// a random key file created next to the store on first use.
+type SecretStore struct {
+	path       string
+	keyPath    string
+	passphrase string
+
+	mu       sync.RWMutex
+	file     secretsFile
+	aead     cipher.AEAD
+	plain    map[string]string
+	replacer *strings.Replacer
+}
+
+func openSecretStore(path, keyPath, passphrase string) (*SecretStore, error) {
+	s := &SecretStore{
+		path:       path,
+		keyPath:    keyPath,
+		passphrase: passphrase,
+		file:       secretsFile{Version: 1, Secrets: make(map[string]*secretRecord)},
+		plain:      make(map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		s.rebuildReplacer()
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.file); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if s.file.Secrets == nil {
+		s.file.Secrets = make(map[string]*secretRecord)
+	}
+
+	switch {
+	case s.file.KDF != nil && passphrase == "":
+		return nil, fmt.Errorf("%s is passphrase-protected; set SM_SECRETS_PASSPHRASE", path)
+	case s.file.KDF == nil && passphrase != "":
+		return nil, fmt.Errorf("%s uses a key file, not a passphrase; unset SM_SECRETS_PASSPHRASE", path)
+	}
+	if err := s.initCipher(false); err != nil {
+		return nil, err
+	}
+	if check, err := s.decrypt("", s.file.Check); err != nil || check != secretsCheckText {
+		return nil, fmt.Errorf("cannot decrypt %s: wrong key or passphrase", path)
+	}
+	for name, rec := range s.file.Secrets {
+		v, err := s.decrypt(name, rec.Value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt secret %s: %w", name, err)
+		}
+		s.plain[name] = v
+	}
+	s.rebuildReplacer()
+	return s, nil
+}
+
+// initCipher derives or loads the key. create is true when writing a new
+// store, in which case a missing key file or salt is generated.
+func (s *SecretStore) initCipher(create bool) error {
+	var key []byte
+	if s.passphrase != "" {
+		if s.file.KDF == nil {
+			salt := make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				return err
+			}
+			s.file.KDF = &secretsKDF{Salt: base64.StdEncoding.EncodeToString(salt), Iterations: secretsKDFIterations}
+		}
+		salt, err := base64.StdEncoding.DecodeString(s.file.KDF.Salt)
+		if err != nil {
+			return fmt.Errorf("invalid kdf salt in %s", s.path)
+		}
+		key, err = pbkdf2.Key(sha256.New, s.passphrase, salt, s.file.KDF.Iterations, 32)
+		if err != nil {
+			return err
+		}
+	} else {
+		var err error
+		if key, err = loadSecretsKey(s.keyPath, create); err != nil {
+			return err
+		}
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	s.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+func loadSecretsKey(path string, create bool) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && create {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			return nil, fmt.Errorf("failed to create key file: %w", err)
+		}
+		log.Printf("[secrets] Generated new key file %s; back it up, secrets cannot be recovered without it", path)
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("secrets key file: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("secrets key file %s must hold 64 hex characters", path)
+	}
+	return key, nil
+}
+
+// The secret name is bound as additional data so ciphertexts cannot be
+// swapped between entries.
+func (s *SecretStore) encrypt(name, plaintext string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), []byte(name))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *SecretStore) decrypt(name, encoded string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	n := s.aead.NonceSize()
+	if len(data) < n {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := s.aead.Open(nil, data[:n], data[n:], []byte(name))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func (s *SecretStore) saveLocked() error {
+	data, err := json.MarshalIndent(s.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".secrets-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *SecretStore) rebuildReplacer() {
+	values := make([]string, 0, len(s.plain))
+	for _, v := range s.plain {
+		if len(v) >= secretMinRedactLen {
+			values = append(values, v)
+		}
+	}
+	// Longest first so a secret containing another is masked whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, redactedValue)
+	}
+	s.replacer = strings.NewReplacer(pairs...)
+}
+
+// List returns metadata for every secret, sorted by name.
+func (s *SecretStore) List() []SecretInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]SecretInfo, 0, len(s.file.Secrets))
+	for name, rec := range s.file.Secrets {
+		out = append(out, SecretInfo{Name: name, CreatedAtMS: rec.CreatedAtMS, UpdatedAtMS: rec.UpdatedAtMS})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Set creates or replaces a secret and reports whether it was new.
+func (s *SecretStore) Set(name, value string) (SecretInfo, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.aead == nil {
+		if err := s.initCipher(true); err != nil {
+			return SecretInfo{}, false, err
+		}
+		check, err := s.encrypt("", secretsCheckText)
+		if err != nil {
+			return SecretInfo{}, false, err
+		}
+		s.file.Check = check
+	}
+
+	sealed, err := s.encrypt(name, value)
+	if err != nil {
+		return SecretInfo{}, false, err
+	}
+	now := time.Now().UnixMilli()
+	rec, exists := s.file.Secrets[name]
+	if !exists {
+		rec = &secretRecord{CreatedAtMS: now}
+	}
+	prev := *rec
+	rec.Value, rec.UpdatedAtMS = sealed, now
+	s.file.Secrets[name] = rec
+	if err := s.saveLocked(); err != nil {
+		if exists {
+			*rec = prev
+		} else {
+			delete(s.file.Secrets, name)
+		}
+		return SecretInfo{}, false, err
+	}
+	s.plain[name] = value
+	s.rebuildReplacer()
+	return SecretInfo{Name: name, CreatedAtMS: rec.CreatedAtMS, UpdatedAtMS: rec.UpdatedAtMS}, !exists, nil
+}
+
+func (s *SecretStore) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.file.Secrets[name]
+	if !ok {
+		return false, nil
+	}
+	delete(s.file.Secrets, name)
+	if err := s.saveLocked(); err != nil {
+		s.file.Secrets[name] = rec
+		return false, err
+	}
+	delete(s.plain, name)
+	s.rebuildReplacer()
+	return true, nil
+}
+
+// Lookup returns a secret's plaintext for substitution into a process.
+func (s *SecretStore) Lookup(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.plain[name]
+	return v, ok
+}
+
+// Redact masks every known secret value in s.
+func (s *SecretStore) Redact(text string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.replacer.Replace(text)
+}
+
+// resolveSecretRefs replaces ${secret:name} references in s.
+func (s *SecretStore) resolveSecretRefs(text string) (string, error) {
+	var missing string
+	out := secretRefPattern.ReplaceAllStringFunc(text, func(ref string) string {
+		name := secretRefPattern.FindStringSubmatch(ref)[1]
+		v, ok := s.Lookup(name)
+		if !ok && missing == "" {
+			missing = name
+		}
+		return v
+	})
+	if missing != "" {
+		return "", fmt.Errorf("unknown secret: %s", missing)
+	}
+	return out, nil
+}
+
+// secretRefs lists the secret names a process config refers to.
+func secretRefs(pc *ProcessConfig) []string {
+	var names []string
+	collect := func(s string) {
+		for _, m := range secretRefPattern.FindAllStringSubmatch(s, -1) {
+			names = append(names, m[1])
+		}
+	}
+	for _, arg := range pc.Args {
+		collect(arg)
+	}
+	for _, v := range pc.Env {
+		collect(v)
+	}
+	for _, inst := range pc.Instances {
+		for _, arg := range inst.Args {
+			collect(arg)
+		}
+		for _, v := range inst.Env {
+			collect(v)
+		}
+	}
+	return names
+}
+
+// checkSecretRefs fails when a config refers to a secret that does not exist.
+func (s *SecretStore) checkSecretRefs(cfg *Config) error {
+	for i := range cfg.Processes {
+		for _, name := range secretRefs(&cfg.Processes[i]) {
+			if _, ok := s.Lookup(name); !ok {
+				return fmt.Errorf("%s: unknown secret: %s", cfg.Processes[i].ID, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ── Handlers ─────────────────────────────────────────────────────────────────
+
+func (pm *ProcessManager) handleListSecrets(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigRead, nil) {
+		return
+	}
+	writeJSON(w, http.StatusOK, pm.secrets.List())
+}
+
+func (pm *ProcessManager) handlePutSecret(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigWrite, nil) {
+		return
+	}
+	name := r.PathValue("name")
+	if !secretNamePattern.MatchString(name) {
+		writeError(w, http.StatusBadRequest, "secret names may only contain letters, digits, '_', '.' and '-'")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 2*secretMaxValueBytes)
+	var body struct {
+		Value *string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Value == nil {
+		writeError(w, http.StatusBadRequest, `expected {"value": "..."}`)
+		return
+	}
+	if len(*body.Value) > secretMaxValueBytes {
+		writeError(w, http.StatusBadRequest, "secret value too large")
+		return
+	}
+
+	info, created, err := pm.secrets.Set(name, *body.Value)
+	pm.recordAudit(r, AuditEntry{Action: AuditSecretSet, Target: name}, err)
+	if err != nil {
+		log.Printf("[secrets] Failed to store %s: %v", name, err)
+		writeError(w, http.StatusInternalServerError, "failed to store secret")
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, info)
+}
+
+func (pm *ProcessManager) handleDeleteSecret(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermConfigWrite, nil) {
+		return
+	}
+	name := r.PathValue("name")
+
+	pm.mu.RLock()
+	var users []string
+	for i := range pm.cfg.Processes {
+		for _, ref := range secretRefs(&pm.cfg.Processes[i]) {
+			if ref == name {
+				users = append(users, pm.cfg.Processes[i].ID)
+				break
+			}
+		}
+	}
+	pm.mu.RUnlock()
+	if len(users) > 0 {
+		writeError(w, http.StatusConflict, "secret is referenced by: "+strings.Join(users, ", "))
+		return
+	}
+
+	deleted, err := pm.secrets.Delete(name)
+	if !deleted && err == nil {
+		writeError(w, http.StatusNotFound, "secret not found")
+		return
+	}
+	pm.recordAudit(r, AuditEntry{Action: AuditSecretDelete, Target: name}, err)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete secret")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "secret deleted"})
+}
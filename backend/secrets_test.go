@@ -0,0 +1,124 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// newTestSecretStore opens an empty store in a temporary directory and sets
+// the given secrets.
+func newTestSecretStore(t *testing.T, secrets map[string]string) *SecretStore {
+	t.Helper()
+	dir := t.TempDir()
+	s, err := openSecretStore(filepath.Join(dir, secretsPath), filepath.Join(dir, secretsKeyPath), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range secrets {
+		if _, _, err := s.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestSecretStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+	path, keyPath := filepath.Join(dir, secretsPath), filepath.Join(dir, secretsKeyPath)
+	s, err := openSecretStore(path, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, created, err := s.Set("db", "hunter22"); err != nil || !created {
+		t.Fatalf("Set = %v, %v", created, err)
+	}
+	if _, created, err := s.Set("db", "hunter23"); err != nil || created {
+		t.Fatalf("second Set = %v, %v", created, err)
+	}
+
+	s, err = openSecretStore(path, keyPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := s.Lookup("db"); !ok || v != "hunter23" {
+		t.Errorf("Lookup after reopen = %q, %v", v, ok)
+	}
+	if _, err := openSecretStore(path, keyPath, "passphrase"); err == nil {
+		t.Error("opening a key-file store with a passphrase succeeded")
+	}
+}
+
+func TestResolveSecretRefs(t *testing.T) {
+	s := newTestSecretStore(t, map[string]string{"db": "hunter22", "api.key": "k-123"})
+	tests := []struct {
+		in, want, wantErr string
+	}{
+		{in: "no refs", want: "no refs"},
+		{in: "${secret:db}", want: "hunter22"},
+		{in: "--password=${secret:db} --key ${secret:api.key}", want: "--password=hunter22 --key k-123"},
+		{in: "$secret:db ${secret:db", want: "$secret:db ${secret:db"},
+		{in: "${DB}", want: "${DB}"},
+		{in: "${secret:nope}", wantErr: "unknown secret: nope"},
+		{in: "${secret:db}${secret:first}${secret:second}", wantErr: "unknown secret: first"},
+	}
+	for _, tt := range tests {
+		got, err := s.resolveSecretRefs(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("resolveSecretRefs(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("resolveSecretRefs(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSecretRefs(t *testing.T) {
+	pc := &ProcessConfig{
+		Args: []string{"--user", "${secret:user}", "--pass=${secret:pass}"},
+		Env:  map[string]string{"TOKEN": "Bearer ${secret:token}", "PLAIN": "$HOME"},
+		Instances: []InstanceConfig{
+			{Args: []string{"${secret:a}${secret:b}"}, Env: map[string]string{"X": "${secret:c}"}},
+		},
+	}
+	got := secretRefs(pc)
+	want := []string{"a", "b", "c", "pass", "token", "user"}
+	slices.Sort(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("secretRefs = %v, want %v", got, want)
+	}
+}
+
+func TestSecretRedact(t *testing.T) {
+	s := newTestSecretStore(t, map[string]string{"short": "abc", "long": "hunter22", "longer": "hunter22-extra"})
+	tests := []struct{ in, want string }{
+		{"nothing here", "nothing here"},
+		{"password hunter22 used", "password " + redactedValue + " used"},
+		{"hunter22-extra", redactedValue},
+		{"abc is too short to mask", "abc is too short to mask"},
+	}
+	for _, tt := range tests {
+		if got := s.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestApplyMapSecrets(t *testing.T) {
+	s := newTestSecretStore(t, map[string]string{"db": "hunter22"})
+	e := &processEnv{vars: make(map[string]*EnvVar), secrets: s}
+	if err := e.applyMap(map[string]string{"URL": "postgres://app:${secret:db}@db", "MISSING": "${secret:nope}"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := e.get("URL"); v != "postgres://app:hunter22@db" {
+		t.Errorf("URL = %q", v)
+	}
+	if e.err == nil || !strings.Contains(e.err.Error(), "unknown secret: nope") {
+		t.Errorf("err = %v, want unknown secret", e.err)
+	}
+}
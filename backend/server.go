@@ -0,0 +1,377 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultListenAddr    = ":8090"
+	defaultSelfSignedCrt = "server.crt"
+	defaultSelfSignedKey = "server.key"
+)
+
+var defaultCORSOrigins = []string{"http://localhost:5173"}
+
+// ServerConfig controls how the HTTP API is exposed. Changes take effect on restart.
+type ServerConfig struct {
+	Listen        string   `json:"listen,omitempty"`
+	TLSCert       string   `json:"tls_cert,omitempty"`
+	TLSKey        string   `json:"tls_key,omitempty"`
+	TLSSelfSigned bool     `json:"tls_self_signed,omitempty"`
+	CORSOrigins   []string `json:"cors_origins,omitempty"`
+	UnixSocket    string   `json:"unix_socket,omitempty"`
+}
+
+// serverFlags holds command-line overrides; empty values fall through to
+// the environment and then to config.json.
+type serverFlags struct {
+	configPath    string
+	listen        string
+	tlsCert       string
+	tlsKey        string
+	tlsSelfSigned bool
+	corsOrigins   string
+	unixSocket    string
+	webDir        string
+}
+
+func parseServerFlags() *serverFlags {
+	f := &serverFlags{}
+	flag.StringVar(&f.configPath, "config", "config.json", "path to config file (.json, .yaml or .toml)")
+	flag.StringVar(&f.listen, "listen", "", "TCP listen address (env SM_LISTEN, default "+defaultListenAddr+")")
+	flag.StringVar(&f.tlsCert, "tls-cert", "", "TLS certificate file (env SM_TLS_CERT)")
+	flag.StringVar(&f.tlsKey, "tls-key", "", "TLS private key file (env SM_TLS_KEY)")
+	flag.BoolVar(&f.tlsSelfSigned, "tls-self-signed", false, "generate a self-signed certificate if none exists (env SM_TLS_SELF_SIGNED)")
+	flag.StringVar(&f.corsOrigins, "cors-origins", "", "comma-separated allowed CORS origins (env SM_CORS_ORIGINS)")
+	flag.StringVar(&f.unixSocket, "unix-socket", "", "also listen on this Unix domain socket (env SM_UNIX_SOCKET)")
+	flag.StringVar(&f.webDir, "web-dir", "", "serve the frontend from this directory instead of the embedded build (env SM_WEB_DIR)")
+	flag.Parse()
+	return f
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// resolve merges flags, environment and config, in that order of precedence.
+func (f *serverFlags) resolve(cfg *ServerConfig) ServerConfig {
+	if cfg == nil {
+		cfg = &ServerConfig{}
+	}
+	sc := ServerConfig{
+		Listen:      firstNonEmpty(f.listen, os.Getenv("SM_LISTEN"), cfg.Listen, defaultListenAddr),
+		TLSCert:     firstNonEmpty(f.tlsCert, os.Getenv("SM_TLS_CERT"), cfg.TLSCert),
+		TLSKey:      firstNonEmpty(f.tlsKey, os.Getenv("SM_TLS_KEY"), cfg.TLSKey),
+		UnixSocket:  firstNonEmpty(f.unixSocket, os.Getenv("SM_UNIX_SOCKET"), cfg.UnixSocket),
+		CORSOrigins: cfg.CORSOrigins,
+	}
+	sc.TLSSelfSigned = f.tlsSelfSigned || os.Getenv("SM_TLS_SELF_SIGNED") == "1" || cfg.TLSSelfSigned
+	if origins := firstNonEmpty(f.corsOrigins, os.Getenv("SM_CORS_ORIGINS")); origins != "" {
+		sc.CORSOrigins = splitList(origins)
+	}
+	if len(sc.CORSOrigins) == 0 {
+		sc.CORSOrigins = defaultCORSOrigins
+	}
+	if sc.TLSSelfSigned {
+		sc.TLSCert = firstNonEmpty(sc.TLSCert, defaultSelfSignedCrt)
+		sc.TLSKey = firstNonEmpty(sc.TLSKey, defaultSelfSignedKey)
+	}
+	return sc
+}
+
+func (sc *ServerConfig) tlsEnabled() bool {
+	return sc.TLSCert != "" && sc.TLSKey != ""
+}
+
+func (sc *ServerConfig) validate() error {
+	if sc == nil {
+		return nil
+	}
+	if (sc.TLSCert == "") != (sc.TLSKey == "") {
+		return fmt.Errorf("server.tls_cert and server.tls_key must be set together")
+	}
+	for _, o := range sc.CORSOrigins {
+		if o == "*" {
+			continue
+		}
+		if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid CORS origin: %s", o)
+		}
+	}
+	return nil
+}
+
+// ── CORS ─────────────────────────────────────────────────────────────────────
+
+type corsPolicy struct {
+	origins []string
+}
+
+func (cp *corsPolicy) allowed(origin string) bool {
+	return slices.Contains(cp.origins, "*") || slices.Contains(cp.origins, origin)
+}
+
+func (cp *corsPolicy) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		if origin := r.Header.Get("Origin"); origin != "" && cp.allowed(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, Last-Event-ID")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkWSOrigin accepts non-browser clients, same-origin pages and the
+// configured CORS origins.
+func (cp *corsPolicy) checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || cp.allowed(origin) {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// ── TLS ──────────────────────────────────────────────────────────────────────
+
+// certReloader serves the certificate at certPath/keyPath and picks up
+// replacements (e.g. renewed certificates) without a restart.
+type certReloader struct {
+	certPath string
+	keyPath  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modTime  time.Time
+}
+
+func newCertReloader(certPath, keyPath string) (*certReloader, error) {
+	cr := &certReloader{certPath: certPath, keyPath: keyPath}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// latestModTime returns the newer of the cert and key modification times.
+func (cr *certReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, p := range []string{cr.certPath, cr.keyPath} {
+		info, err := os.Stat(p)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func (cr *certReloader) reload() error {
+	modTime, err := cr.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certPath, cr.keyPath)
+	if err != nil {
+		return err
+	}
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.modTime = modTime
+	cr.mu.Unlock()
+	return nil
+}
+
+// watch polls the cert and key files and reloads them when either changes.
+// A failed reload keeps serving the previous certificate.
+func (cr *certReloader) watch() {
+	ticker := time.NewTicker(5 * time.Second)
+	for range ticker.C {
+		modTime, err := cr.latestModTime()
+		if err != nil {
+			continue
+		}
+		cr.mu.RLock()
+		changed := !modTime.Equal(cr.modTime)
+		cr.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := cr.reload(); err != nil {
+			log.Printf("[tls] failed to reload certificate: %v", err)
+			continue
+		}
+		log.Printf("[tls] reloaded certificate %s", cr.certPath)
+	}
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// ensureSelfSignedCert writes a self-signed certificate for localhost, this
+// host's name and its interface addresses unless the files already exist.
+func ensureSelfSignedCert(certPath, keyPath string) error {
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if certErr == nil && keyErr == nil {
+		return nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "server-manager", Organization: []string{"Server Manager self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		tmpl.DNSNames = append(tmpl.DNSNames, host)
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	log.Printf("[tls] generated self-signed certificate %s", certPath)
+	return nil
+}
+
+// ── Listeners ────────────────────────────────────────────────────────────────
+
+// serve starts the TCP listener (TLS when configured) and the optional Unix
+// socket listener, returning the first fatal error.
+func serve(sc ServerConfig, handler http.Handler) error {
+	errCh := make(chan error, 2)
+
+	srv := &http.Server{Addr: sc.Listen, Handler: handler}
+	scheme := "http"
+	if sc.tlsEnabled() {
+		if sc.TLSSelfSigned {
+			if err := ensureSelfSignedCert(sc.TLSCert, sc.TLSKey); err != nil {
+				return fmt.Errorf("failed to generate self-signed certificate: %w", err)
+			}
+		}
+		cr, err := newCertReloader(sc.TLSCert, sc.TLSKey)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		go cr.watch()
+		srv.TLSConfig = &tls.Config{GetCertificate: cr.getCertificate, MinVersion: tls.VersionTLS12}
+		scheme = "https"
+	}
+
+	go func() {
+		if sc.tlsEnabled() {
+			errCh <- srv.ListenAndServeTLS("", "")
+		} else {
+			errCh <- srv.ListenAndServe()
+		}
+	}()
+	log.Printf("Server manager backend running on %s://%s", scheme, displayAddr(sc.Listen))
+
+	if sc.UnixSocket != "" {
+		// A socket file left behind by an unclean exit would make Listen
+		// fail, but anything else at the path is not ours to delete.
+		if fi, err := os.Lstat(sc.UnixSocket); err == nil {
+			if fi.Mode()&os.ModeSocket == 0 {
+				return fmt.Errorf("unix socket path %s exists and is not a socket", sc.UnixSocket)
+			}
+			if err := os.Remove(sc.UnixSocket); err != nil {
+				return fmt.Errorf("failed to remove stale unix socket: %w", err)
+			}
+		}
+		ln, err := net.Listen("unix", sc.UnixSocket)
+		if err != nil {
+			return fmt.Errorf("failed to listen on unix socket: %w", err)
+		}
+		os.Chmod(sc.UnixSocket, 0660)
+		go func() {
+			errCh <- http.Serve(ln, handler)
+		}()
+		log.Printf("Server manager backend listening on unix:%s", sc.UnixSocket)
+	}
+
+	return <-errCh
+}
+
+func displayAddr(addr string) string {
+	if strings.HasPrefix(addr, ":") {
+		return "localhost" + addr
+	}
+	return addr
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sseReplaySize        = 256              // events and alerts kept for Last-Event-ID
+	sseHeartbeatInterval = 15 * time.Second // comment lines keep proxies from timing out
+	sseRetryMS           = 3000             // reconnect delay suggested to EventSource
+)
+
+// sseTopics are the topics GET /api/stream serves. Status is sent as a
+// fresh snapshot on every connect, so only events and alerts are replayed.
+var (
+	sseTopics       = []string{TopicStatus, TopicEvents, TopicAlerts}
+	sseReplayTopics = map[string]bool{TopicEvents: true, TopicAlerts: true}
+)
+
+// replayEntry is a publication kept for reconnecting event streams. It is
+// rendered again for each user that replays it.
+type replayEntry struct {
+	id  uint64
+	seq uint64
+	msg wsMessage
+}
+
+// remember adds an entry to the replay buffer. Caller must hold h.mu.
+func (h *WSHub) remember(e replayEntry) {
+	if len(h.replay) == sseReplaySize {
+		h.replayDropped = h.replay[0].id
+		h.replay = h.replay[1:]
+	}
+	h.replay = append(h.replay, e)
+}
+
+// sseFrame formats a message as a server-sent event. Messages without an ID
+// (status) leave the client's last event ID alone.
+func sseFrame(id uint64, event string, data []byte) []byte {
+	var b strings.Builder
+	if id > 0 {
+		fmt.Fprintf(&b, "id: %d\n", id)
+	}
+	fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event, data)
+	return []byte(b.String())
+}
+
+// registerSSE adds an event stream client and queues the buffered events it
+// missed since lastID. Both happen under h.mu, so nothing published in
+// between is lost or sent twice.
+func (h *WSHub) registerSSE(r *http.Request, topics []string, lastID uint64, resume bool) *wsClient {
+	c := newWSClient(r)
+	c.sse = true
+	c.version = wsProtocolVersion
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.addLocked(c)
+	h.subMu.Lock()
+	for _, topic := range topics {
+		c.subs[topic] = true
+		h.subscribers[topic]++
+	}
+	h.subMu.Unlock()
+
+	if !resume {
+		return c
+	}
+	// An ID from the future means the server restarted since
+	if lastID < h.replayDropped || lastID > h.eventID {
+		data, _ := json.Marshal(wsErrorMessage("", "some events since the last event ID are no longer available"))
+		c.enqueue(sseFrame(0, WSError, data))
+	}
+	for _, e := range h.replay {
+		if e.id <= lastID || !c.subs[e.msg.topic] {
+			continue
+		}
+		v := e.msg.render(c.user)
+		if v == nil {
+			continue
+		}
+		data, err := json.Marshal(wsEnvelope{Type: WSUpdate, Topic: e.msg.topic, Seq: e.seq, Data: v})
+		if err == nil {
+			c.enqueue(sseFrame(e.id, e.msg.topic, data))
+		}
+	}
+	return c
+}
+
+// handleStream serves the hub's status, events and alerts topics as
+// server-sent events, for clients that cannot use WebSockets. ?topics= picks
+// a subset. Event data is the same envelope the WebSocket sends, and the
+// event name is the topic.
+func (pm *ProcessManager) handleStream(w http.ResponseWriter, r *http.Request) {
+	topics := sseTopics
+	if q := r.URL.Query().Get("topics"); q != "" {
+		topics = nil
+		for _, topic := range strings.Split(q, ",") {
+			topic = strings.TrimSpace(topic)
+			if !slices.Contains(sseTopics, topic) {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("unknown topic %q (want %s)", topic, strings.Join(sseTopics, ", ")))
+				return
+			}
+			topics = append(topics, topic)
+		}
+	}
+
+	// EventSource resends the last ID it saw in a header when it reconnects;
+	// the query parameter lets a fresh page pick up where an old one stopped.
+	lastRaw := r.Header.Get("Last-Event-ID")
+	if lastRaw == "" {
+		lastRaw = r.URL.Query().Get("last_event_id")
+	}
+	var lastID uint64
+	if lastRaw != "" {
+		n, err := strconv.ParseUint(lastRaw, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+		lastID = n
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // don't let nginx hold events back
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetryMS)
+	if err := rc.Flush(); err != nil {
+		log.Printf("[sse] streaming not supported: %v", err)
+		return
+	}
+
+	c := pm.hub.registerSSE(r, topics, lastID, lastRaw != "")
+	defer pm.hub.unregister(c)
+	if c.subs[TopicStatus] {
+		pm.hub.sendStatusSnapshot(c, pm.statuses())
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		var data []byte
+		select {
+		case data = <-c.queue:
+		case <-c.wake:
+			msg := c.nextStatus()
+			if msg == nil {
+				continue
+			}
+			data = sseFrame(0, TopicStatus, msg)
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+			continue
+		case <-c.done:
+			if c.closeReason != "" {
+				msg, _ := json.Marshal(wsErrorMessage("", c.closeReason))
+				rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				w.Write(sseFrame(0, WSError, msg))
+				rc.Flush()
+			}
+			return
+		case <-r.Context().Done():
+			return
+		}
+
+		rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if _, err := w.Write(data); err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		c.sent.Add(1)
+		if len(c.queue) < cap(c.queue)/2 {
+			c.fullSince.Store(0)
+		}
+	}
+}
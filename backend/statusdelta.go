@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+)
+
+// statusFields is one process's status as marshalled JSON fields, so two
+// snapshots can be compared field by field.
+type statusFields map[string]json.RawMessage
+
+// statusSnapshot is the process status list one user may see, as of a
+// status topic sequence number.
+type statusSnapshot struct {
+	seq    uint64
+	order  []string
+	fields map[string]statusFields
+}
+
+// statusDelta brings a client from the snapshot numbered Base to the one in
+// the envelope. Each entry in Changed is a JSON merge patch for the process
+// with that id: fields that were dropped are null, and new processes come
+// with every field. Order is sent when the process list or its order changed.
+type statusDelta struct {
+	Base    uint64         `json:"base"`
+	Changed []statusFields `json:"changed,omitempty"`
+	Removed []string       `json:"removed,omitempty"`
+	Order   []string       `json:"order,omitempty"`
+}
+
+func newStatusSnapshot(seq uint64, statuses []ProcessStatus) *statusSnapshot {
+	snap := &statusSnapshot{
+		seq:    seq,
+		order:  make([]string, 0, len(statuses)),
+		fields: make(map[string]statusFields, len(statuses)),
+	}
+	for _, st := range statuses {
+		data, err := json.Marshal(st)
+		if err != nil {
+			continue
+		}
+		var fields statusFields
+		if err := json.Unmarshal(data, &fields); err != nil {
+			continue
+		}
+		snap.order = append(snap.order, st.ID)
+		snap.fields[st.ID] = fields
+	}
+	return snap
+}
+
+// list returns the full status list, for snapshot messages.
+func (s *statusSnapshot) list() []statusFields {
+	list := make([]statusFields, 0, len(s.order))
+	for _, id := range s.order {
+		list = append(list, s.fields[id])
+	}
+	return list
+}
+
+// diff returns what changed between prev and s, or nil if nothing did.
+func (s *statusSnapshot) diff(prev *statusSnapshot) *statusDelta {
+	delta := &statusDelta{Base: prev.seq}
+	for _, id := range s.order {
+		cur, old := s.fields[id], prev.fields[id]
+		if old == nil {
+			delta.Changed = append(delta.Changed, cur)
+			continue
+		}
+		patch := statusFields{}
+		for k, v := range cur {
+			if !bytes.Equal(v, old[k]) {
+				patch[k] = v
+			}
+		}
+		for k := range old {
+			if _, ok := cur[k]; !ok {
+				patch[k] = json.RawMessage("null")
+			}
+		}
+		if len(patch) > 0 {
+			patch["id"] = cur["id"]
+			delta.Changed = append(delta.Changed, patch)
+		}
+	}
+	for _, id := range prev.order {
+		if _, ok := s.fields[id]; !ok {
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	if !slices.Equal(s.order, prev.order) {
+		delta.Order = s.order
+	}
+	if len(delta.Changed) == 0 && len(delta.Removed) == 0 && delta.Order == nil {
+		return nil
+	}
+	return delta
+}
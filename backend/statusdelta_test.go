@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStatusSnapshotDiff(t *testing.T) {
+	web := ProcessStatus{ID: "web", Name: "Web", State: StateRunning, PID: 10, CPU: 1.5}
+	db := ProcessStatus{ID: "db", Name: "DB", State: StateStopped}
+	crashed := web
+	crashed.State, crashed.PID, crashed.CrashReason = StateCrashed, 0, "oom_killed"
+	restarted := crashed
+	restarted.State, restarted.PID, restarted.CrashReason = StateRunning, 11, ""
+
+	tests := []struct {
+		name      string
+		prev, cur []ProcessStatus
+		want      string // JSON of the delta, or "null"
+	}{
+		{
+			name: "nothing changed",
+			prev: []ProcessStatus{web, db},
+			cur:  []ProcessStatus{web, db},
+			want: `null`,
+		},
+		{
+			name: "changed fields only",
+			prev: []ProcessStatus{web, db},
+			cur:  []ProcessStatus{crashed, db},
+			want: `{"base":1,"changed":[{"crash_reason":"oom_killed","id":"web","pid":0,"state":"crashed"}]}`,
+		},
+		{
+			name: "dropped field is null",
+			prev: []ProcessStatus{crashed},
+			cur:  []ProcessStatus{restarted},
+			want: `{"base":1,"changed":[{"crash_reason":null,"id":"web","pid":11,"state":"running"}]}`,
+		},
+		{
+			name: "added process has every field and an order",
+			prev: []ProcessStatus{web},
+			cur:  []ProcessStatus{web, db},
+			want: `{"base":1,"changed":[` + statusJSON(t, db) + `],"order":["web","db"]}`,
+		},
+		{
+			name: "removed process",
+			prev: []ProcessStatus{web, db},
+			cur:  []ProcessStatus{db},
+			want: `{"base":1,"removed":["web"],"order":["db"]}`,
+		},
+		{
+			name: "reordered",
+			prev: []ProcessStatus{web, db},
+			cur:  []ProcessStatus{db, web},
+			want: `{"base":1,"order":["db","web"]}`,
+		},
+		{
+			name: "everything removed",
+			prev: []ProcessStatus{web},
+			cur:  nil,
+			want: `{"base":1,"removed":["web"]}`, // an empty order is left out
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := newStatusSnapshot(2, tt.cur).diff(newStatusSnapshot(1, tt.prev))
+			got, err := json.Marshal(delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotDoc, wantDoc any
+			json.Unmarshal(got, &gotDoc)
+			if err := json.Unmarshal([]byte(tt.want), &wantDoc); err != nil {
+				t.Fatalf("bad want: %v", err)
+			}
+			if !reflect.DeepEqual(gotDoc, wantDoc) {
+				t.Errorf("delta\n got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func statusJSON(t *testing.T, st ProcessStatus) string {
+	t.Helper()
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
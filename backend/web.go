@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// web/dist is populated by `npm run build` in frontend/. The directory may
+// be empty in a source checkout, in which case the API still works and the
+// root page explains how to build the UI.
+//
+//go:embed all:web
+var embeddedWeb embed.FS
+
+// webHandler serves the single-page frontend with SPA fallback routing,
+// pre-compressed variants and cache headers suited to Vite's hashed assets.
+type webHandler struct {
+	files    fs.FS
+	embedded bool     // files never change, so ETags can be cached
+	etags    sync.Map // path → strong ETag
+}
+
+// newWebHandler serves from dir when set, otherwise from the embedded build.
+func newWebHandler(dir string) *webHandler {
+	if dir != "" {
+		return &webHandler{files: os.DirFS(dir)}
+	}
+	sub, _ := fs.Sub(embeddedWeb, "web/dist")
+	return &webHandler{files: sub, embedded: true}
+}
+
+func (wh *webHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" {
+		name = "index.html"
+	}
+	if !wh.exists(name) {
+		// Client-side routes have no extension; missing assets are real 404s.
+		if path.Ext(name) != "" {
+			http.NotFound(w, r)
+			return
+		}
+		name = "index.html"
+	}
+	if name == "index.html" && !wh.exists(name) {
+		http.Error(w, "frontend not built: run `npm run build` in frontend/ and rebuild, or pass -web-dir", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(name, "assets/"):
+		// Vite fingerprints everything under assets/, so it never changes.
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	case name == "index.html":
+		w.Header().Set("Cache-Control", "no-cache")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+	}
+
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	served := name
+	accept := r.Header.Get("Accept-Encoding")
+	for _, enc := range []struct{ token, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+		if acceptsEncoding(accept, enc.token) && wh.exists(name+enc.ext) {
+			served = name + enc.ext
+			w.Header().Set("Content-Encoding", enc.token)
+			break
+		}
+	}
+
+	data, err := fs.ReadFile(wh.files, served)
+	if err != nil {
+		http.Error(w, "failed to read asset", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", wh.etag(served, data))
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows coding,
+// either by name or through "*". A q-value of 0 rules a coding out.
+func acceptsEncoding(header, coding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(name, coding) && name != "*" {
+			continue
+		}
+		ok := true
+		for _, param := range strings.Split(params, ";") {
+			key, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				ok = err == nil && q > 0
+			}
+		}
+		if name == "*" {
+			wildcard = ok
+		} else {
+			// An explicit entry for the coding overrides "*"
+			return ok
+		}
+	}
+	return wildcard
+}
+
+func (wh *webHandler) exists(name string) bool {
+	info, err := fs.Stat(wh.files, name)
+	return err == nil && !info.IsDir()
+}
+
+func (wh *webHandler) etag(name string, data []byte) string {
+	if tag, ok := wh.etags.Load(name); ok {
+		return tag.(string)
+	}
+	sum := sha256.Sum256(data)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if wh.embedded {
+		wh.etags.Store(name, tag)
+	}
+	return tag
+}
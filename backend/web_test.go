@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, coding string
+		want           bool
+	}{
+		{"", "gzip", false},
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate, br", "gzip", true},
+		{"br;q=0, gzip", "br", false},
+		{"br;q=0, gzip", "gzip", true},
+		{"BR;Q=0.5", "br", true},
+		{"gzip;q=0.000", "gzip", false},
+		{"gzip;q=bogus", "gzip", false},
+		{"x-gzip-like", "gzip", false},
+		{"*", "br", true},
+		{"*;q=0", "gzip", false},
+		{"*, br;q=0", "br", false},
+		{"br;q=0, *", "br", false},
+		{"gzip;q=0, *;q=1", "br", true},
+	}
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.coding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %v, want %v", tt.header, tt.coding, got, tt.want)
+		}
+	}
+}
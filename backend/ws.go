@@ -2,69 +2,586 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // local only — no origin restrictions needed
-	},
+// upgrader's CheckOrigin is set from the CORS policy in main.
+var upgrader = websocket.Upgrader{}
+
+// ── Protocol ─────────────────────────────────────────────────────────────────
+
+// wsProtocolVersion is the newest protocol the hub speaks. Version 1 is the
+// original feed of bare status arrays; clients get it until they send a
+// hello message asking for a newer version.
+const wsProtocolVersion = 2
+
+// Message types
+const (
+	WSHello        = "hello"
+	WSWelcome      = "welcome"
+	WSSubscribe    = "subscribe"
+	WSUnsubscribe  = "unsubscribe"
+	WSSubscribed   = "subscribed"
+	WSUnsubscribed = "unsubscribed"
+	WSUpdate       = "update"
+	WSSnapshot     = "snapshot" // full status list; later status updates are deltas
+	WSResync       = "resync"   // client asks for a fresh status snapshot
+	WSError        = "error"
+	WSPing         = "ping"
+	WSPong         = "pong"
+	WSCommand      = "command" // lifecycle command; answered with ack, then result
+	WSAck          = "ack"
+	WSResult       = "result"
+)
+
+// Topics. Per-process topics are the prefix followed by the process ID.
+const (
+	TopicStatus        = "status"
+	TopicEvents        = "events"
+	TopicAlerts        = "alerts" // crashes and disk-space events
+	TopicHost          = "host"
+	TopicOperations    = "operations"
+	TopicLogsPrefix    = "logs:"
+	TopicMetricsPrefix = "metrics:"
+)
+
+var wsTopics = []string{TopicStatus, TopicEvents, TopicAlerts, TopicHost, TopicOperations,
+	TopicLogsPrefix + "{id}", TopicMetricsPrefix + "{id}"}
+
+// wsEnvelope wraps every version 2 message. Seq numbers the messages
+// published on a topic.
+type wsEnvelope struct {
+	Type  string `json:"type"`
+	ID    string `json:"id,omitempty"` // echoes a command's request ID
+	Topic string `json:"topic,omitempty"`
+	Seq   uint64 `json:"seq,omitempty"`
+	Data  any    `json:"data,omitempty"`
+}
+
+type wsClientMessage struct {
+	Type    string   `json:"type"`
+	Version int      `json:"version,omitempty"`
+	Topic   string   `json:"topic,omitempty"`
+	Topics  []string `json:"topics,omitempty"`
+
+	// Commands
+	ID          string `json:"id,omitempty"`
+	Command     string `json:"command,omitempty"`
+	Process     string `json:"process,omitempty"`
+	AutoRestart *bool  `json:"auto_restart,omitempty"`
+	Input       string `json:"input,omitempty"`
+}
+
+func wsErrorMessage(topic, msg string) wsEnvelope {
+	return wsEnvelope{Type: WSError, Topic: topic, Data: map[string]string{"message": msg}}
+}
+
+// ── Hub ──────────────────────────────────────────────────────────────────────
+
+const (
+	wsSendQueueSize     = 256              // messages buffered per client
+	wsWriteTimeout      = 10 * time.Second // per frame
+	wsPongWait          = 60 * time.Second // a client silent this long is gone
+	wsPingInterval      = wsPongWait * 9 / 10
+	wsSlowClientTimeout = 15 * time.Second // how long a client's queue may stay full
+	wsMaxMessageSize    = 64 * 1024
+)
+
+// wsClient is one connection. The hub queues messages for it and a writer
+// goroutine drains the queue, so a stalled client only holds up itself.
+// Server-sent event streams are clients too, with a nil conn; their handler
+// is the writer.
+type wsClient struct {
+	id          uint64
+	conn        *websocket.Conn
+	sse         bool
+	user        *User
+	remoteAddr  string
+	connectedAt time.Time
+
+	// guarded by WSHub.mu
+	version int
+	subs    map[string]bool
+
+	queue chan []byte
+	wake  chan struct{} // signals a new status snapshot
+
+	// Status snapshots bypass the queue: only the latest one matters, so a
+	// newer snapshot replaces one the client has not been sent yet. Version 1
+	// clients get the marshalled list; newer ones get a delta against
+	// sentStatus, which only the writer touches.
+	legacyStatus  []byte
+	pendingStatus *statusSnapshot
+	resync        bool // send pendingStatus whole
+	statusMu      sync.Mutex
+	sentStatus    *statusSnapshot
+
+	done        chan struct{} // closed to stop the writer
+	closeOnce   sync.Once
+	closeCode   int
+	closeReason string
+
+	sent, dropped, coalesced atomic.Uint64
+	fullSince                atomic.Int64 // unix ms the queue filled up, 0 if it has room
+}
+
+// wsMessage is a publication on a topic. render returns the topic data for
+// one user, or nil to skip them; legacy returns what version 1 clients get,
+// and is nil for topics they never received.
+type wsMessage struct {
+	topic  string
+	render func(u *User) any
+	legacy func(u *User) any
 }
 
 type WSHub struct {
-	clients map[*websocket.Conn]bool
-	mu      sync.Mutex
-	msgCh   chan []byte
+	clients map[*wsClient]bool
+	seq     map[string]uint64
+	nextID  uint64
+	mu      sync.Mutex // guards clients, seq, nextID, replay and each client's version and subs
+	msgCh   chan wsMessage
+	dropped atomic.Uint64 // publications lost because msgCh was full
+
+	// Every publication gets a hub-wide event ID; recent events and alerts
+	// are kept so reconnecting event streams can catch up.
+	eventID       uint64
+	replay        []replayEntry
+	replayDropped uint64 // ID of the newest entry pushed out of replay
+
+	// Subscriber counts have their own lock so publishers can check for
+	// interest cheaply, whatever locks they hold.
+	subscribers map[string]int
+	subMu       sync.Mutex
 }
 
 func newWSHub() *WSHub {
 	return &WSHub{
-		clients: make(map[*websocket.Conn]bool),
-		msgCh:   make(chan []byte, 64),
+		clients:     make(map[*wsClient]bool),
+		seq:         make(map[string]uint64),
+		msgCh:       make(chan wsMessage, 256),
+		subscribers: make(map[string]int),
 	}
 }
 
 func (h *WSHub) run() {
 	for msg := range h.msgCh {
 		h.mu.Lock()
-		for conn := range h.clients {
-			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				conn.Close()
-				delete(h.clients, conn)
+		h.seq[msg.topic]++
+		seq := h.seq[msg.topic]
+		h.eventID++
+		id := h.eventID
+		if sseReplayTopics[msg.topic] {
+			h.remember(replayEntry{id: id, seq: seq, msg: msg})
+		}
+		// Clients sharing a user (including the local user when auth is off)
+		// get the same payload, so render and marshal it once per user.
+		current := make(map[*User][]byte)
+		legacy := make(map[*User][]byte)
+		snapshots := make(map[*User]*statusSnapshot)
+		for c := range h.clients {
+			var data []byte
+			switch {
+			case c.version < 2 && msg.legacy != nil:
+				data = encodeOnce(legacy, c.user, msg.legacy)
+			case c.version >= 2 && c.subs[msg.topic] && msg.topic == TopicStatus:
+				snap, ok := snapshots[c.user]
+				if !ok {
+					statuses, _ := msg.render(c.user).([]ProcessStatus)
+					snap = newStatusSnapshot(seq, statuses)
+					snapshots[c.user] = snap
+				}
+				c.setStatus(snap, false)
+			case c.version >= 2 && c.subs[msg.topic]:
+				data = encodeOnce(current, c.user, func(u *User) any {
+					if v := msg.render(u); v != nil {
+						return wsEnvelope{Type: WSUpdate, Topic: msg.topic, Seq: seq, Data: v}
+					}
+					return nil
+				})
+			}
+			if data == nil {
+				continue
+			}
+			switch {
+			case msg.topic == TopicStatus:
+				c.setLegacyStatus(data)
+			case c.sse:
+				c.enqueue(sseFrame(id, msg.topic, data))
+			default:
+				c.enqueue(data)
 			}
 		}
 		h.mu.Unlock()
 	}
 }
 
-func (h *WSHub) broadcast(data any) {
-	msg, err := json.Marshal(data)
-	if err != nil {
-		return
+// encodeOnce renders and marshals a payload for u, reusing earlier results
+// for the same user. A nil result means u gets nothing.
+func encodeOnce(cache map[*User][]byte, u *User, render func(u *User) any) []byte {
+	if data, ok := cache[u]; ok {
+		return data
 	}
+	var data []byte
+	if v := render(u); v != nil {
+		data, _ = json.Marshal(v)
+	}
+	cache[u] = data
+	return data
+}
+
+// publish hands a message to the hub without blocking; publishers may hold
+// process locks that rendering needs.
+func (h *WSHub) publish(msg wsMessage) {
 	select {
 	case h.msgCh <- msg:
 	default:
-		// drop if channel full (client too slow)
+		h.dropped.Add(1)
 	}
 }
 
-func (h *WSHub) register(conn *websocket.Conn) {
+// subscribed reports whether any client is subscribed to topic, so costly
+// publications can be skipped.
+func (h *WSHub) subscribed(topic string) bool {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	return h.subscribers[topic] > 0
+}
+
+// subscribedWithPrefix lists the subscribed topics starting with prefix.
+func (h *WSHub) subscribedWithPrefix(prefix string) []string {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	var topics []string
+	for topic, n := range h.subscribers {
+		if n > 0 && strings.HasPrefix(topic, prefix) {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+func (h *WSHub) broadcastStatuses(statuses []ProcessStatus) {
+	render := func(u *User) any {
+		return u.visibleStatuses(statuses)
+	}
+	h.publish(wsMessage{topic: TopicStatus, render: render, legacy: render})
+}
+
+func newWSClient(r *http.Request) *wsClient {
+	return &wsClient{
+		user:        requestUser(r),
+		remoteAddr:  r.RemoteAddr,
+		connectedAt: time.Now(),
+		version:     1,
+		subs:        make(map[string]bool),
+		queue:       make(chan []byte, wsSendQueueSize),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+}
+
+// addLocked starts delivering to c. Caller must hold h.mu.
+func (h *WSHub) addLocked(c *wsClient) {
+	h.nextID++
+	c.id = h.nextID
+	h.clients[c] = true
+}
+
+func (h *WSHub) register(conn *websocket.Conn, r *http.Request) *wsClient {
+	c := newWSClient(r)
+	c.conn = conn
+	h.mu.Lock()
+	h.addLocked(c)
+	h.mu.Unlock()
+	go c.writePump()
+	return c
+}
+
+func (h *WSHub) unregister(c *wsClient) {
 	h.mu.Lock()
-	h.clients[conn] = true
+	if h.clients[c] {
+		delete(h.clients, c)
+		h.subMu.Lock()
+		for topic := range c.subs {
+			h.subscribers[topic]--
+		}
+		h.subMu.Unlock()
+	}
 	h.mu.Unlock()
+	c.close(websocket.CloseNormalClosure, "")
 }
 
-func (h *WSHub) unregister(conn *websocket.Conn) {
+func (h *WSHub) setVersion(c *wsClient, version int) {
 	h.mu.Lock()
-	delete(h.clients, conn)
+	c.version = version
 	h.mu.Unlock()
 }
 
+// requireVersion tells the client off if it has not negotiated at least
+// the given protocol version.
+func (h *WSHub) requireVersion(c *wsClient, version int) bool {
+	h.mu.Lock()
+	ok := c.version >= version
+	h.mu.Unlock()
+	if !ok {
+		h.send(c, wsErrorMessage("", fmt.Sprintf("send a hello message with version %d first", version)))
+	}
+	return ok
+}
+
+func (h *WSHub) subscribe(c *wsClient, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if c.subs[topic] {
+		return
+	}
+	c.subs[topic] = true
+	h.subMu.Lock()
+	h.subscribers[topic]++
+	h.subMu.Unlock()
+}
+
+func (h *WSHub) unsubscribe(c *wsClient, topic string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !c.subs[topic] {
+		return
+	}
+	delete(c.subs, topic)
+	h.subMu.Lock()
+	h.subscribers[topic]--
+	h.subMu.Unlock()
+}
+
+// send queues a message for one client.
+func (h *WSHub) send(c *wsClient, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[ws] marshal error: %v", err)
+		return
+	}
+	c.enqueue(data)
+}
+
+// sendStatusSnapshot sends one client the full status list, numbered with
+// the status topic's latest sequence number. Later updates are deltas
+// against it.
+func (h *WSHub) sendStatusSnapshot(c *wsClient, statuses []ProcessStatus) {
+	h.mu.Lock()
+	seq := h.seq[TopicStatus]
+	h.mu.Unlock()
+	c.setStatus(newStatusSnapshot(seq, c.user.visibleStatuses(statuses)), true)
+}
+
+// ── Client queues ────────────────────────────────────────────────────────────
+
+// enqueue adds a message to the client's queue, dropping it if the queue is
+// full. A client whose queue stays full for wsSlowClientTimeout is evicted.
+func (c *wsClient) enqueue(data []byte) {
+	select {
+	case c.queue <- data:
+		return
+	default:
+	}
+	c.dropped.Add(1)
+	now := time.Now().UnixMilli()
+	if c.fullSince.CompareAndSwap(0, now) {
+		return
+	}
+	if now-c.fullSince.Load() > wsSlowClientTimeout.Milliseconds() {
+		c.close(websocket.CloseTryAgainLater, "client too slow")
+	}
+}
+
+// setLegacyStatus replaces any status list still waiting to be written.
+func (c *wsClient) setLegacyStatus(data []byte) {
+	c.statusMu.Lock()
+	if c.legacyStatus != nil {
+		c.coalesced.Add(1)
+	}
+	c.legacyStatus = data
+	c.statusMu.Unlock()
+	c.wakeWriter()
+}
+
+// setStatus replaces any status snapshot still waiting to be written. A
+// resync request sticks until the writer sends the snapshot whole.
+func (c *wsClient) setStatus(snap *statusSnapshot, resync bool) {
+	c.statusMu.Lock()
+	if c.pendingStatus != nil {
+		c.coalesced.Add(1)
+	}
+	c.pendingStatus = snap
+	c.resync = c.resync || resync
+	c.statusMu.Unlock()
+	c.wakeWriter()
+}
+
+func (c *wsClient) wakeWriter() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextStatus takes the pending status list or snapshot and returns the
+// message to write for it, or nil if there is nothing to send.
+func (c *wsClient) nextStatus() []byte {
+	c.statusMu.Lock()
+	legacy, snap, resync := c.legacyStatus, c.pendingStatus, c.resync
+	c.legacyStatus, c.pendingStatus, c.resync = nil, nil, false
+	c.statusMu.Unlock()
+	if snap != nil {
+		return c.statusMessage(snap, resync)
+	}
+	return legacy
+}
+
+// statusMessage encodes a status snapshot as a delta against the last one
+// sent, or whole when the client has none or asked for a resync. It returns
+// nil when nothing changed. Only the writer calls it.
+func (c *wsClient) statusMessage(snap *statusSnapshot, resync bool) []byte {
+	prev := c.sentStatus
+	var msg wsEnvelope
+	if prev == nil || resync {
+		msg = wsEnvelope{Type: WSSnapshot, Topic: TopicStatus, Seq: snap.seq, Data: snap.list()}
+	} else if delta := snap.diff(prev); delta != nil {
+		msg = wsEnvelope{Type: WSUpdate, Topic: TopicStatus, Seq: snap.seq, Data: delta}
+	} else {
+		return nil
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil
+	}
+	c.sentStatus = snap
+	return data
+}
+
+// close stops the writer, which sends a close frame with the given code and
+// reason before closing the connection.
+func (c *wsClient) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode, c.closeReason = code, reason
+		if reason != "" {
+			log.Printf("[ws] closing client %d (%s): %s", c.id, c.remoteAddr, reason)
+		}
+		close(c.done)
+	})
+}
+
+// writePump is the only goroutine writing to the connection. Closing the
+// connection on exit also ends the read loop in handleWS.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingInterval)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		var data []byte
+		select {
+		case data = <-c.queue:
+		case <-c.wake:
+			if data = c.nextStatus(); data == nil {
+				continue
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		case <-c.done:
+			msg := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+			c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(wsWriteTimeout))
+			return
+		}
+
+		c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			return
+		}
+		c.sent.Add(1)
+		if len(c.queue) < cap(c.queue)/2 {
+			c.fullSince.Store(0)
+		}
+	}
+}
+
+// WSClientInfo describes a connected client for the admin endpoint.
+type WSClientInfo struct {
+	ID          uint64   `json:"id"`
+	Transport   string   `json:"transport"` // websocket or sse
+	User        string   `json:"user,omitempty"`
+	RemoteAddr  string   `json:"remote_addr"`
+	ConnectedMS int64    `json:"connected_at_ms"`
+	Version     int      `json:"version"`
+	Topics      []string `json:"topics"`
+	Queued      int      `json:"queued"`
+	Sent        uint64   `json:"sent"`
+	Dropped     uint64   `json:"dropped"`   // messages lost because the queue was full
+	Coalesced   uint64   `json:"coalesced"` // status snapshots replaced by newer ones
+}
+
+// clientInfo lists the connected clients, oldest first.
+func (h *WSHub) clientInfo() []WSClientInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	infos := make([]WSClientInfo, 0, len(h.clients))
+	for c := range h.clients {
+		topics := make([]string, 0, len(c.subs))
+		for topic := range c.subs {
+			topics = append(topics, topic)
+		}
+		sort.Strings(topics)
+		transport := "websocket"
+		if c.sse {
+			transport = "sse"
+		}
+		infos = append(infos, WSClientInfo{
+			ID:          c.id,
+			Transport:   transport,
+			User:        c.user.Name,
+			RemoteAddr:  c.remoteAddr,
+			ConnectedMS: c.connectedAt.UnixMilli(),
+			Version:     c.version,
+			Topics:      topics,
+			Queued:      len(c.queue),
+			Sent:        c.sent.Load(),
+			Dropped:     c.dropped.Load(),
+			Coalesced:   c.coalesced.Load(),
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// handleGetWSClients reports each WebSocket client's queue and drop counters.
+// Like the audit log it reveals who is connected, so it needs audit:read.
+func (pm *ProcessManager) handleGetWSClients(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, PermAuditRead, nil) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"clients":    pm.hub.clientInfo(),
+		"queue_size": wsSendQueueSize,
+		"dropped":    pm.hub.dropped.Load(),
+	})
+}
+
+// ── Connection handling ──────────────────────────────────────────────────────
+
 func (pm *ProcessManager) handleWS(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -72,16 +589,185 @@ func (pm *ProcessManager) handleWS(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pm.hub.register(conn)
-	defer func() {
-		pm.hub.unregister(conn)
-		conn.Close()
-	}()
+	c := pm.hub.register(conn, r)
+	defer pm.hub.unregister(c)
+
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
 
-	// Keep alive — read loop discards client messages (ping/pong handled automatically)
 	for {
-		if _, _, err := conn.ReadMessage(); err != nil {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		var msg wsClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			pm.hub.send(c, wsErrorMessage("", "invalid JSON"))
+			continue
+		}
+		pm.handleWSMessage(c, msg)
+	}
+}
+
+func (pm *ProcessManager) handleWSMessage(c *wsClient, msg wsClientMessage) {
+	switch msg.Type {
+	case WSHello:
+		version := min(max(msg.Version, 1), wsProtocolVersion)
+		pm.hub.setVersion(c, version)
+		pm.hub.send(c, wsEnvelope{Type: WSWelcome, Data: map[string]any{"version": version, "topics": wsTopics}})
+	case WSPing:
+		pm.hub.send(c, wsEnvelope{Type: WSPong})
+	case WSResync:
+		if !pm.hub.requireVersion(c, 2) {
+			return
+		}
+		if msg.Topic != TopicStatus {
+			pm.hub.send(c, wsErrorMessage(msg.Topic, "only the status topic can be resynced"))
+			return
+		}
+		pm.hub.mu.Lock()
+		subscribed := c.subs[TopicStatus]
+		pm.hub.mu.Unlock()
+		if !subscribed {
+			pm.hub.send(c, wsErrorMessage(msg.Topic, "not subscribed"))
+			return
+		}
+		pm.hub.sendStatusSnapshot(c, pm.statuses())
+	case WSCommand:
+		if !pm.hub.requireVersion(c, 2) {
+			return
+		}
+		pm.handleWSCommand(c, msg)
+	case WSSubscribe, WSUnsubscribe:
+		if !pm.hub.requireVersion(c, 2) {
+			return
+		}
+		topics := msg.Topics
+		if msg.Topic != "" {
+			topics = append(topics, msg.Topic)
+		}
+		for _, topic := range topics {
+			if msg.Type == WSUnsubscribe {
+				pm.hub.unsubscribe(c, topic)
+				pm.hub.send(c, wsEnvelope{Type: WSUnsubscribed, Topic: topic})
+				continue
+			}
+			if err := pm.authorizeTopic(c.user, topic); err != nil {
+				pm.hub.send(c, wsErrorMessage(topic, err.Error()))
+				continue
+			}
+			pm.hub.subscribe(c, topic)
+			pm.hub.send(c, wsEnvelope{Type: WSSubscribed, Topic: topic})
+			if topic == TopicStatus {
+				// Don't make the client wait for the next tick
+				pm.hub.sendStatusSnapshot(c, pm.statuses())
+			}
+		}
+	default:
+		pm.hub.send(c, wsErrorMessage("", fmt.Sprintf("unknown message type %q", msg.Type)))
+	}
+}
+
+// authorizeTopic checks that a topic exists and u may subscribe to it.
+// Shared topics are filtered per message instead.
+func (pm *ProcessManager) authorizeTopic(u *User, topic string) error {
+	switch topic {
+	case TopicStatus, TopicEvents, TopicAlerts, TopicOperations:
+		return nil
+	case TopicHost:
+		if !u.can(PermView, "", "") {
+			return fmt.Errorf("permission denied: %s", PermView)
+		}
+		return nil
+	}
+
+	perm, id := "", ""
+	if rest, ok := strings.CutPrefix(topic, TopicLogsPrefix); ok {
+		perm, id = PermLogsRead, rest
+	} else if rest, ok := strings.CutPrefix(topic, TopicMetricsPrefix); ok {
+		perm, id = PermView, rest
+	} else {
+		return fmt.Errorf("unknown topic %q", topic)
+	}
+	pm.mu.RLock()
+	mp, ok := pm.processes[id]
+	pm.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("process not found: %s", id)
+	}
+	if !u.canProcess(perm, mp.Config()) {
+		return fmt.Errorf("permission denied: %s", perm)
+	}
+	if perm == PermLogsRead && mp.Config().IsService {
+		return fmt.Errorf("services have no managed log")
+	}
+	return nil
+}
+
+// ── Publishers ───────────────────────────────────────────────────────────────
+
+// alertEvents are the event types also published on the alerts topic.
+var alertEvents = map[string]bool{
+	EventCrashed:            true,
+	EventDiskWarning:        true,
+	EventDiskCritical:       true,
+	EventDiskRecovered:      true,
+	EventConfigReloadFailed: true,
+	EventConfigRecovered:    true,
+}
+
+// publishEvent pushes a newly recorded event to subscribers who may view
+// its process (or the host, for host events).
+func (pm *ProcessManager) publishEvent(ev Event) {
+	render := func(u *User) any {
+		category := ""
+		pm.mu.RLock()
+		if mp, ok := pm.processes[ev.ProcessID]; ok {
+			category = mp.Config().Category
+		}
+		pm.mu.RUnlock()
+		if !u.can(PermView, ev.ProcessID, category) {
+			return nil
+		}
+		return ev
+	}
+	pm.hub.publish(wsMessage{topic: TopicEvents, render: render})
+	if alertEvents[ev.Type] {
+		pm.hub.publish(wsMessage{topic: TopicAlerts, render: render})
+	}
+}
+
+// publishMetrics pushes a process's latest metric point, if anyone is
+// subscribed to it.
+func (pm *ProcessManager) publishMetrics(pc *ProcessConfig, point MetricPoint) {
+	topic := TopicMetricsPrefix + pc.ID
+	if !pm.hub.subscribed(topic) {
+		return
+	}
+	id, category := pc.ID, pc.Category
+	pm.hub.publish(wsMessage{topic: topic, render: func(u *User) any {
+		if !u.can(PermView, id, category) {
+			return nil
+		}
+		return point
+	}})
+}
+
+// statuses returns the status of every process in config order.
+func (pm *ProcessManager) statuses() []ProcessStatus {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	statuses := make([]ProcessStatus, 0, len(pm.order))
+	for _, id := range pm.order {
+		mp := pm.processes[id]
+		mp.mu.Lock()
+		statuses = append(statuses, pm.getStatus(mp))
+		mp.mu.Unlock()
 	}
+	return statuses
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// WebSocket commands mirror the lifecycle endpoints: the same permission
+// checks, operations and audit entries, attributed to the connection's user
+// and address. A command that is rejected gets an error; an accepted one
+// gets an ack and later a result, all carrying the client's request ID.
+
+// Commands
+const (
+	CmdStart          = "start"
+	CmdStop           = "stop"
+	CmdRestart        = "restart"
+	CmdSetAutoRestart = "set_auto_restart"
+	CmdConsole        = "console"
+)
+
+// wsCommandResult is the data of a result message.
+type wsCommandResult struct {
+	OK          bool       `json:"ok"`
+	Error       string     `json:"error,omitempty"`
+	Operation   *Operation `json:"operation,omitempty"`
+	AutoRestart *bool      `json:"auto_restart,omitempty"`
+}
+
+// wsCommandError rejects a command. Code is the status the REST endpoint
+// would have answered with.
+func wsCommandError(id string, code int, msg string, extra map[string]any) wsEnvelope {
+	data := map[string]any{"message": msg, "code": code}
+	for k, v := range extra {
+		data[k] = v
+	}
+	return wsEnvelope{Type: WSError, ID: id, Data: data}
+}
+
+func (pm *ProcessManager) handleWSCommand(c *wsClient, msg wsClientMessage) {
+	if msg.ID == "" {
+		pm.hub.send(c, wsCommandError("", http.StatusBadRequest, "command needs an id", nil))
+		return
+	}
+	perm := PermControl
+	switch msg.Command {
+	case CmdStart, CmdStop, CmdRestart, CmdSetAutoRestart:
+	case CmdConsole:
+		perm = PermConsole
+	default:
+		pm.hub.send(c, wsCommandError(msg.ID, http.StatusBadRequest, fmt.Sprintf("unknown command %q", msg.Command), nil))
+		return
+	}
+
+	pm.mu.RLock()
+	mp, ok := pm.processes[msg.Process]
+	pm.mu.RUnlock()
+	if !ok {
+		pm.hub.send(c, wsCommandError(msg.ID, http.StatusNotFound, "process not found", nil))
+		return
+	}
+	if !c.user.canProcess(perm, mp.Config()) {
+		pm.hub.send(c, wsCommandError(msg.ID, http.StatusForbidden, "permission denied: "+perm, nil))
+		return
+	}
+	a := actor{User: c.user, RemoteAddr: c.remoteAddr}
+
+	switch msg.Command {
+	case CmdStart, CmdStop, CmdRestart:
+		pm.runWSOperation(c, msg.ID, a, msg.Command, mp)
+	case CmdSetAutoRestart:
+		if msg.AutoRestart == nil {
+			pm.hub.send(c, wsCommandError(msg.ID, http.StatusBadRequest, "auto_restart is required", nil))
+			return
+		}
+		pm.hub.send(c, wsEnvelope{Type: WSAck, ID: msg.ID})
+		pm.applyAutoRestart(a, mp, *msg.AutoRestart)
+		pm.hub.send(c, wsEnvelope{Type: WSResult, ID: msg.ID, Data: wsCommandResult{OK: true, AutoRestart: msg.AutoRestart}})
+	case CmdConsole:
+		if err := validConsoleInput(msg.Input); err != nil {
+			pm.hub.send(c, wsCommandError(msg.ID, http.StatusBadRequest, err.Error(), nil))
+			return
+		}
+		pm.hub.send(c, wsEnvelope{Type: WSAck, ID: msg.ID})
+		result := wsCommandResult{OK: true}
+		if err := pm.sendConsole(a, mp, msg.Input); err != nil {
+			result = wsCommandResult{Error: err.Error()}
+		}
+		pm.hub.send(c, wsEnvelope{Type: WSResult, ID: msg.ID, Data: result})
+	}
+}
+
+// runWSOperation submits a lifecycle operation, acks with its initial state
+// and sends the final state once it has finished.
+func (pm *ProcessManager) runWSOperation(c *wsClient, id string, a actor, action string, mp *ManagedProcess) {
+	op, err := pm.submitOperation(a, action, []*ManagedProcess{mp})
+	if err != nil {
+		if conflict, ok := err.(*opConflictError); ok {
+			pm.hub.send(c, wsCommandError(id, http.StatusConflict, conflict.Error(),
+				map[string]any{"operation_id": conflict.OperationID}))
+			return
+		}
+		pm.hub.send(c, wsCommandError(id, http.StatusInternalServerError, err.Error(), nil))
+		return
+	}
+	s := pm.ops.snapshot(op)
+	pm.hub.send(c, wsEnvelope{Type: WSAck, ID: id, Data: s})
+
+	go func() {
+		select {
+		case <-op.done:
+		case <-c.done:
+			return
+		}
+		s := pm.ops.snapshot(op)
+		result := wsCommandResult{OK: s.State == OpSucceeded, Operation: &s}
+		for _, st := range s.Steps {
+			if st.Error != "" {
+				result.Error = st.Error
+			}
+		}
+		pm.hub.send(c, wsEnvelope{Type: WSResult, ID: id, Data: result})
+	}()
+}
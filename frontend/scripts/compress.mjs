@@ -0,0 +1,29 @@
+// Writes .br and .gz siblings for compressible build output so the Go
+// server can send pre-compressed assets without compressing per request.
+import { readdirSync, readFileSync, statSync, writeFileSync } from 'node:fs'
+import { join } from 'node:path'
+import { fileURLToPath } from 'node:url'
+import { brotliCompressSync, gzipSync, constants } from 'node:zlib'
+
+const OUT_DIR = fileURLToPath(new URL('../../backend/web/dist/', import.meta.url))
+const COMPRESSIBLE = /\.(html|js|mjs|css|svg|json|txt|map)$/
+const MIN_SIZE = 1024
+
+function walk(dir) {
+  for (const name of readdirSync(dir)) {
+    const path = join(dir, name)
+    if (statSync(path).isDirectory()) {
+      walk(path)
+      continue
+    }
+    if (!COMPRESSIBLE.test(name)) continue
+    const data = readFileSync(path)
+    if (data.length < MIN_SIZE) continue
+    writeFileSync(`${path}.br`, brotliCompressSync(data, {
+      params: { [constants.BROTLI_PARAM_QUALITY]: constants.BROTLI_MAX_QUALITY },
+    }))
+    writeFileSync(`${path}.gz`, gzipSync(data, { level: 9 }))
+  }
+}
+
+walk(OUT_DIR)
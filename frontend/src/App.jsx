@@ -5,10 +5,32 @@ import ConfigEditor from './components/ConfigEditor'
 import ComparisonView from './components/ComparisonView'
 import EventTimeline from './components/EventTimeline'
 import LogViewer from './components/LogViewer'
+import HostPanel from './components/HostPanel'
+import TokenPrompt from './components/TokenPrompt'
+import { apiFetch, getToken, setToken, withToken } from './api'
 
-const WS_URL = 'ws://localhost:8090/ws'
+const WS_URL = `${location.protocol === 'https:' ? 'wss' : 'ws'}://${location.host}/ws`
 const RECONNECT_DELAY = 3000
 const HISTORY_MAX = 30
+const WS_PROTOCOL_VERSION = 2
+
+// Applies a status delta: each changed entry is a JSON merge patch for one
+// process, and order (when present) gives the new process order.
+function applyStatusDelta(list, delta) {
+  const byId = new Map(list.map(p => [p.id, p]))
+  for (const id of delta.removed ?? []) byId.delete(id)
+  const order = delta.order ?? list.map(p => p.id).filter(id => byId.has(id))
+  for (const patch of delta.changed ?? []) {
+    const next = { ...byId.get(patch.id) }
+    for (const [key, value] of Object.entries(patch)) {
+      if (value === null) delete next[key]
+      else next[key] = value
+    }
+    if (!byId.has(patch.id) && !delta.order) order.push(patch.id)
+    byId.set(patch.id, next)
+  }
+  return order.map(id => byId.get(id)).filter(Boolean)
+}
 
 export default function App() {
   const [processes, setProcesses] = useState([])
@@ -18,11 +40,16 @@ export default function App() {
   const [configOpen, setConfigOpen] = useState(false)
   const [compareOpen, setCompareOpen] = useState(false)
   const [logViewerOpen, setLogViewerOpen] = useState(false)
+  const [host, setHost] = useState(null)
+  const [authPrompt, setAuthPrompt] = useState(null) // { invalid } while a token is needed
   const wsRef = useRef(null)
   const reconnectTimer = useRef(null)
   const cpuHistoryRef = useRef({})   // { [id]: number[] } — rolling 30 CPU samples
   const memHistoryRef = useRef({})   // { [id]: number[] } — rolling 30 memory (MB) samples
   const prevStatesRef = useRef({})   // { [id]: string }  — previous state for crash detection
+  const statusRef = useRef(null)     // { seq, list } — last status applied, for deltas
+  const commandsRef = useRef({})     // { [requestId]: resolve } — commands awaiting a result
+  const readyRef = useRef(false)     // welcome received, commands can go over the socket
 
   // Set theme on root element and localStorage
   useEffect(() => {
@@ -30,51 +57,99 @@ export default function App() {
     localStorage.setItem('theme', theme)
   }, [theme])
 
+  // Any 401 means the manager wants a token we don't have, or no longer accepts ours
+  useEffect(() => {
+    const onAuthRequired = () => setAuthPrompt({ invalid: getToken() !== '' })
+    window.addEventListener('auth-required', onAuthRequired)
+    return () => window.removeEventListener('auth-required', onAuthRequired)
+  }, [])
+
   const dismissToast = useCallback((id) => {
     setToasts(t => t.filter(x => x.id !== id))
   }, [])
 
   const connect = useCallback(() => {
-    const ws = new WebSocket(WS_URL)
+    const ws = new WebSocket(withToken(WS_URL))
     wsRef.current = ws
 
     ws.onopen = () => {
+      statusRef.current = null
+      readyRef.current = false
       setConnected(true)
       clearTimeout(reconnectTimer.current)
+      ws.send(JSON.stringify({ type: 'hello', version: WS_PROTOCOL_VERSION }))
     }
 
-    ws.onmessage = (e) => {
-      try {
-        const updated = JSON.parse(e.data)
+    const handleStatus = (updated) => {
+      updated.forEach(proc => {
+        const { id, state, cpu, memory_mb, name } = proc
 
-        updated.forEach(proc => {
-          const { id, state, cpu, memory_mb, name } = proc
+        // CPU sparkline history
+        const cpuHist = cpuHistoryRef.current[id] ?? []
+        cpuHistoryRef.current[id] = [...cpuHist, cpu].slice(-HISTORY_MAX)
 
-          // CPU sparkline history
-          const cpuHist = cpuHistoryRef.current[id] ?? []
-          cpuHistoryRef.current[id] = [...cpuHist, cpu].slice(-HISTORY_MAX)
+        // Memory sparkline history
+        const memHist = memHistoryRef.current[id] ?? []
+        memHistoryRef.current[id] = [...memHist, memory_mb].slice(-HISTORY_MAX)
 
-          // Memory sparkline history
-          const memHist = memHistoryRef.current[id] ?? []
-          memHistoryRef.current[id] = [...memHist, memory_mb].slice(-HISTORY_MAX)
+        // Crash toast
+        const prevState = prevStatesRef.current[id]
+        if (prevState && prevState !== 'crashed' && state === 'crashed') {
+          setToasts(t => [...t, { id: crypto.randomUUID(), name }])
+        }
+        prevStatesRef.current[id] = state
+      })
 
-          // Crash toast
-          const prevState = prevStatesRef.current[id]
-          if (prevState && prevState !== 'crashed' && state === 'crashed') {
-            setToasts(t => [...t, { id: crypto.randomUUID(), name }])
-          }
-          prevStatesRef.current[id] = state
-        })
+      setProcesses(updated)
+    }
 
-        setProcesses(updated)
+    ws.onmessage = (e) => {
+      try {
+        const msg = JSON.parse(e.data)
+        const resolve = msg.id && commandsRef.current[msg.id]
+        if (resolve && (msg.type === 'result' || msg.type === 'error')) {
+          delete commandsRef.current[msg.id]
+          resolve(msg.type === 'result' ? msg.data : { ok: false, error: msg.data?.message })
+        } else if (msg.type === 'welcome') {
+          readyRef.current = true
+          ws.send(JSON.stringify({ type: 'subscribe', topics: ['status', 'host'] }))
+        } else if (msg.type === 'snapshot' && msg.topic === 'status') {
+          statusRef.current = { seq: msg.seq ?? 0, list: msg.data ?? [] }
+          handleStatus(statusRef.current.list)
+        } else if (msg.type === 'update' && msg.topic === 'status') {
+          const current = statusRef.current
+          if (!current) return // waiting for a snapshot
+          if (current.seq !== msg.data.base) {
+            // Missed an update: drop deltas until a fresh snapshot arrives
+            statusRef.current = null
+            ws.send(JSON.stringify({ type: 'resync', topic: 'status' }))
+            return
+          }
+          statusRef.current = { seq: msg.seq, list: applyStatusDelta(current.list, msg.data) }
+          handleStatus(statusRef.current.list)
+        } else if (msg.type === 'update' && msg.topic === 'host') {
+          setHost(msg.data)
+        }
       } catch {
         // ignore malformed messages
       }
     }
 
     ws.onclose = () => {
+      readyRef.current = false
+      for (const resolve of Object.values(commandsRef.current)) {
+        resolve({ ok: false, error: 'connection lost' })
+      }
+      commandsRef.current = {}
       setConnected(false)
-      reconnectTimer.current = setTimeout(connect, RECONNECT_DELAY)
+      // The browser hides why the socket failed, so check whether it was
+      // refused for want of a token before retrying; the prompt reconnects
+      apiFetch('/api/processes')
+        .then(res => res.status !== 401)
+        .catch(() => true)
+        .then(retry => {
+          if (retry) reconnectTimer.current = setTimeout(connect, RECONNECT_DELAY)
+        })
     }
 
     ws.onerror = () => ws.close()
@@ -88,16 +163,41 @@ export default function App() {
     }
   }, [connect])
 
+  function handleToken(token) {
+    setToken(token)
+    setAuthPrompt(null)
+    clearTimeout(reconnectTimer.current)
+    const ws = wsRef.current
+    if (ws && ws.readyState !== WebSocket.CLOSED) ws.close() // reconnects with the new token
+    else connect()
+  }
+
+  // Sends a lifecycle command over the WebSocket and resolves with its
+  // result. Returns null when the socket is not ready, so callers can fall
+  // back to the REST endpoint.
+  function sendCommand(command, process, params = {}) {
+    const ws = wsRef.current
+    if (!ws || ws.readyState !== WebSocket.OPEN || !readyRef.current) return null
+    const id = crypto.randomUUID()
+    return new Promise(resolve => {
+      commandsRef.current[id] = resolve
+      ws.send(JSON.stringify({ type: 'command', id, command, process, ...params }))
+    })
+  }
+
   async function handleStart(id) {
-    await fetch(`/api/processes/${id}/start`, { method: 'POST' })
+    if (await sendCommand('start', id)) return
+    await apiFetch(`/api/processes/${encodeURIComponent(id)}/start`, { method: 'POST' })
   }
 
   async function handleStop(id) {
-    await fetch(`/api/processes/${id}/stop`, { method: 'POST' })
+    if (await sendCommand('stop', id)) return
+    await apiFetch(`/api/processes/${encodeURIComponent(id)}/stop`, { method: 'POST' })
   }
 
   async function handleToggleAutoRestart(id, value) {
-    await fetch(`/api/processes/${id}/autorestart`, {
+    if (await sendCommand('set_auto_restart', id, { auto_restart: value })) return
+    await apiFetch(`/api/processes/${encodeURIComponent(id)}/autorestart`, {
       method: 'PUT',
       headers: { 'Content-Type': 'application/json' },
       body: JSON.stringify({ auto_restart: value }),
@@ -105,11 +205,11 @@ export default function App() {
   }
 
   async function handleStartAll() {
-    await fetch('/api/processes/start-all', { method: 'POST' })
+    await apiFetch('/api/processes/start-all', { method: 'POST' })
   }
 
   async function handleStopAll() {
-    await fetch('/api/processes/stop-all', { method: 'POST' })
+    await apiFetch('/api/processes/stop-all', { method: 'POST' })
   }
 
   const runningCount = processes.filter(p => p.state === 'running').length
@@ -195,6 +295,8 @@ export default function App() {
         </div>
       </header>
 
+      <HostPanel host={host} />
+
       <main className="process-main">
         {processes.length === 0 && (
           <div className="empty-state">
@@ -234,6 +336,8 @@ export default function App() {
         />
       )}
 
+      {authPrompt && <TokenPrompt invalid={authPrompt.invalid} onSubmit={handleToken} />}
+
       <Toast toasts={toasts} onDismiss={dismissToast} />
     </div>
   )
@@ -0,0 +1,30 @@
+// Requests to the backend carry the bearer token entered in the token
+// prompt, once the manager has an auth section.
+const TOKEN_KEY = 'token'
+
+export function getToken() {
+  return localStorage.getItem(TOKEN_KEY) ?? ''
+}
+
+export function setToken(token) {
+  if (token) localStorage.setItem(TOKEN_KEY, token)
+  else localStorage.removeItem(TOKEN_KEY)
+}
+
+// Adds the token as a query parameter, for WebSocket and EventSource
+// connections, which can't send an Authorization header.
+export function withToken(url) {
+  const token = getToken()
+  if (!token) return url
+  return `${url}${url.includes('?') ? '&' : '?'}token=${encodeURIComponent(token)}`
+}
+
+// fetch with the Authorization header set. A 401 fires an auth-required
+// event on window so the app can ask for a (new) token.
+export async function apiFetch(url, options = {}) {
+  const token = getToken()
+  const headers = token ? { ...options.headers, Authorization: `Bearer ${token}` } : options.headers
+  const res = await fetch(url, { ...options, headers })
+  if (res.status === 401) window.dispatchEvent(new Event('auth-required'))
+  return res
+}
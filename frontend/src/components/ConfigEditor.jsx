@@ -1,10 +1,13 @@
 import { useEffect, useState } from 'react'
+import { apiFetch } from '../api'
 
 export default function ConfigEditor({ onClose }) {
   const [config, setConfig] = useState('')
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState('')
   const [success, setSuccess] = useState(false)
+  // ETag of the config as loaded, so a save can't overwrite someone else's edit
+  const [etag, setEtag] = useState('')
 
   // Auto-dismiss success message after 3 seconds
   useEffect(() => {
@@ -16,8 +19,9 @@ export default function ConfigEditor({ onClose }) {
   useEffect(() => {
     const fetchConfig = async () => {
       try {
-        const res = await fetch('/api/config')
+        const res = await apiFetch('/api/config')
         if (res.ok) {
+          setEtag(res.headers.get('ETag') || '')
           const text = await res.text()
           // Pretty-print JSON
           const parsed = JSON.parse(text)
@@ -53,14 +57,19 @@ export default function ConfigEditor({ onClose }) {
 
     // Send to backend
     try {
-      const res = await fetch('/api/config', {
+      const headers = { 'Content-Type': 'application/json' }
+      if (etag) headers['If-Match'] = etag
+      const res = await apiFetch('/api/config', {
         method: 'PUT',
-        headers: { 'Content-Type': 'application/json' },
+        headers,
         body: config,
       })
 
       if (res.ok) {
+        setEtag(res.headers.get('ETag') || '')
         setSuccess(true)
+      } else if (res.status === 412) {
+        setError('The config was changed elsewhere since it was loaded. Reopen the editor to load the latest version.')
       } else {
         const errData = await res.json()
         setError(errData.error || 'Failed to save config')
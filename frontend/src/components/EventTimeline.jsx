@@ -1,4 +1,5 @@
 import { useEffect, useState } from 'react'
+import { apiFetch } from '../api'
 
 export default function EventTimeline() {
   const [open, setOpen] = useState(false)
@@ -9,7 +10,7 @@ export default function EventTimeline() {
 
     const fetchEvents = async () => {
       try {
-        const res = await fetch('/api/events')
+        const res = await apiFetch('/api/events')
         if (res.ok) {
           const data = await res.json()
           setEvents(data || [])
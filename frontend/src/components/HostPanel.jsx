@@ -0,0 +1,121 @@
+import { useEffect, useState } from 'react'
+import { apiFetch } from '../api'
+
+function formatBytes(bytes) {
+  if (bytes >= 1024 ** 4) return `${(bytes / 1024 ** 4).toFixed(1)} TB`
+  if (bytes >= 1024 ** 3) return `${(bytes / 1024 ** 3).toFixed(1)} GB`
+  if (bytes >= 1024 ** 2) return `${(bytes / 1024 ** 2).toFixed(0)} MB`
+  return `${(bytes / 1024).toFixed(0)} KB`
+}
+
+function formatRate(bps) {
+  return `${formatBytes(bps)}/s`
+}
+
+function formatUptime(sec) {
+  const days = Math.floor(sec / 86400)
+  const hours = Math.floor((sec % 86400) / 3600)
+  const mins = Math.floor((sec % 3600) / 60)
+  return days > 0 ? `${days}d ${hours}h` : `${hours}h ${mins}m`
+}
+
+function Bar({ percent, warn, critical }) {
+  const level = percent >= critical ? 'critical' : percent >= warn ? 'warning' : ''
+  return (
+    <div className="host-bar">
+      <div className={`host-bar-fill ${level}`} style={{ width: `${Math.min(percent, 100)}%` }} />
+    </div>
+  )
+}
+
+export default function HostPanel({ host }) {
+  const [open, setOpen] = useState(true)
+  const [thresholds, setThresholds] = useState({ disk_warn_percent: 85, disk_critical_percent: 95 })
+  const [alerts, setAlerts] = useState([])
+
+  // Thresholds and active alerts are not part of the live stream
+  useEffect(() => {
+    if (!open) return
+
+    const fetchHost = async () => {
+      try {
+        const res = await apiFetch('/api/host?minutes=1')
+        if (res.ok) {
+          const data = await res.json()
+          setThresholds(data.thresholds)
+          setAlerts(data.disk_alerts || [])
+        }
+      } catch {
+        // ignore fetch errors
+      }
+    }
+
+    fetchHost()
+    const interval = setInterval(fetchHost, 10000)
+    return () => clearInterval(interval)
+  }, [open])
+
+  if (!host) return null
+
+  const warn = thresholds.disk_warn_percent
+  const critical = thresholds.disk_critical_percent
+  const alerted = Object.fromEntries(alerts.map(a => [a.mount, a.level]))
+  const swapPercent = host.swap_total_bytes > 0 ? host.swap_used_bytes / host.swap_total_bytes * 100 : 0
+
+  return (
+    <section className="timeline-section host-section">
+      <button className="timeline-toggle" onClick={() => setOpen(!open)} aria-expanded={open}>
+        <span className="timeline-caret">▼</span>
+        Host
+        {alerts.length > 0 && <span className="host-alert-badge">{alerts.length} disk alert{alerts.length > 1 ? 's' : ''}</span>}
+      </button>
+
+      {open && (
+        <div className="host-grid">
+          <div className="host-item">
+            <span className="header-stat-label">CPU</span>
+            <span className="header-stat-value">{host.cpu.toFixed(1)}% · {host.cpu_per_core?.length ?? 0} cores</span>
+            <Bar percent={host.cpu} warn={90} critical={100} />
+            <div className="host-cores">
+              {(host.cpu_per_core ?? []).map((c, i) => (
+                <div key={i} className="host-core" title={`Core ${i}: ${c.toFixed(0)}%`}>
+                  <div className="host-core-fill" style={{ height: `${Math.min(c, 100)}%` }} />
+                </div>
+              ))}
+            </div>
+          </div>
+          <div className="host-item">
+            <span className="header-stat-label">Memory</span>
+            <span className="header-stat-value">{formatBytes(host.mem_used_bytes)} / {formatBytes(host.mem_total_bytes)}</span>
+            <Bar percent={host.mem_used_percent} warn={90} critical={97} />
+            {host.swap_total_bytes > 0 && (
+              <>
+                <span className="header-stat-label">Swap</span>
+                <span className="header-stat-value">{formatBytes(host.swap_used_bytes)} / {formatBytes(host.swap_total_bytes)}</span>
+                <Bar percent={swapPercent} warn={50} critical={90} />
+              </>
+            )}
+          </div>
+          <div className="host-item">
+            <span className="header-stat-label">Load</span>
+            <span className="header-stat-value">{host.load1.toFixed(2)} · {host.load5.toFixed(2)} · {host.load15.toFixed(2)}</span>
+            <span className="header-stat-label">Network</span>
+            <span className="header-stat-value">↓ {formatRate(host.net_rx_bps)} · ↑ {formatRate(host.net_tx_bps)}</span>
+            <span className="header-stat-label">Uptime</span>
+            <span className="header-stat-value">{formatUptime(host.uptime_sec)}</span>
+          </div>
+          <div className="host-item host-disks">
+            <span className="header-stat-label">Disks</span>
+            {(host.disks ?? []).map(d => (
+              <div key={d.mount} className={`host-disk ${alerted[d.mount] ?? ''}`}>
+                <span className="host-disk-mount" title={d.device}>{d.mount}</span>
+                <Bar percent={d.used_percent} warn={warn} critical={critical} />
+                <span className="host-disk-free">{formatBytes(d.free_bytes)} free</span>
+              </div>
+            ))}
+          </div>
+        </div>
+      )}
+    </section>
+  )
+}
@@ -1,4 +1,5 @@
 import { useState, useEffect, useRef } from 'react'
+import { apiFetch } from '../api'
 
 function formatLogSize(bytes) {
   if (!bytes) return null
@@ -32,7 +33,7 @@ export default function LogViewer({ processes, onClose }) {
 
     const fetchLogs = async () => {
       try {
-        const res = await fetch(`/api/processes/${selectedId}/logs?tail=500`)
+        const res = await apiFetch(`/api/processes/${encodeURIComponent(selectedId)}/logs?tail=500`)
         const data = await res.json()
         if (!cancelled) {
           setLogLines(data.lines ?? [])
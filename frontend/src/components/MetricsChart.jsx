@@ -1,4 +1,5 @@
 import { useEffect, useState } from 'react'
+import { apiFetch } from '../api'
 
 export default function MetricsChart({ processId }) {
   const [minutes, setMinutes] = useState(5)
@@ -13,7 +14,7 @@ export default function MetricsChart({ processId }) {
   useEffect(() => {
     const fetchMetrics = async () => {
       try {
-        const res = await fetch(`/api/processes/${processId}/metrics?minutes=${minutes}`)
+        const res = await apiFetch(`/api/processes/${encodeURIComponent(processId)}/metrics?minutes=${minutes}`)
         if (res.ok) {
           const data = await res.json()
           setPoints(data.points || [])
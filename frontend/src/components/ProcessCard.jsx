@@ -1,5 +1,6 @@
 import { useState, useEffect } from 'react'
 import MetricsChart from './MetricsChart'
+import { apiFetch } from '../api'
 
 function formatUptime(ms) {
   const s = Math.floor(ms / 1000)
@@ -107,7 +108,7 @@ export default function ProcessCard({ process, onStart, onStop, onToggleAutoRest
 
     const fetchLogs = async () => {
       try {
-        const res = await fetch(`/api/processes/${id}/logs?tail=100`)
+        const res = await apiFetch(`/api/processes/${encodeURIComponent(id)}/logs?tail=100`)
         const data = await res.json()
         if (!cancelled) {
           setLogLines(data.lines ?? [])
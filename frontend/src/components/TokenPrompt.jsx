@@ -0,0 +1,43 @@
+import { useState } from 'react'
+
+export default function TokenPrompt({ invalid, onSubmit }) {
+  const [token, setToken] = useState('')
+
+  const handleSubmit = (e) => {
+    e.preventDefault()
+    if (token.trim()) onSubmit(token.trim())
+  }
+
+  return (
+    <div className="modal-overlay">
+      <form className="modal token-modal" onSubmit={handleSubmit}>
+        <div className="modal-header">
+          <h2 className="modal-title">Sign In</h2>
+        </div>
+
+        <div className="modal-body">
+          {invalid && (
+            <div className="config-error">
+              ✕ The saved token was rejected.
+            </div>
+          )}
+          <p className="token-hint">This server manager requires an API token.</p>
+          <input
+            className="token-input"
+            type="password"
+            value={token}
+            onChange={(e) => setToken(e.target.value)}
+            placeholder="Token"
+            autoFocus
+          />
+        </div>
+
+        <div className="modal-footer">
+          <button className="btn btn-start btn-sm" type="submit" disabled={!token.trim()}>
+            Connect
+          </button>
+        </div>
+      </form>
+    </div>
+  )
+}
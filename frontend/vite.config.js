@@ -3,10 +3,16 @@ import react from '@vitejs/plugin-react'
 
 export default defineConfig({
   plugins: [react()],
+  build: {
+    // Embedded into the Go binary via backend/web.go
+    outDir: '../backend/web/dist',
+    emptyOutDir: true,
+  },
   server: {
     port: 5173,
     proxy: {
       '/api': 'http://localhost:8090',
+      '/ws': { target: 'ws://localhost:8090', ws: true },
     },
   },
 })